@@ -0,0 +1,89 @@
+package watermeter
+
+import (
+	"time"
+)
+
+// An AlertKind identifies a type of alarm raised by a watermeter.
+type AlertKind int
+
+const (
+	// AlertStuck is raised when updates arrive but the total stops changing.
+	AlertStuck AlertKind = iota
+
+	// AlertIdle is raised when updates stop arriving.
+	AlertIdle
+)
+
+// String returns the name of the alert kind.
+func (k AlertKind) String() string {
+	switch k {
+	case AlertStuck:
+		return "stuck"
+	case AlertIdle:
+		return "idle"
+	}
+	return "unknown"
+}
+
+// alertBuffer is the capacity of the channel returned by Alerts.
+const alertBuffer = 16
+
+// An Alert is an alarm raised by a watermeter.
+type Alert struct {
+	// Kind is the type of alarm.
+	Kind AlertKind
+
+	// Time is when the alarm was raised.
+	Time time.Time
+
+	// Since is when the condition began: the last change of the total for
+	// AlertStuck and the last update for AlertIdle.
+	Since time.Time
+}
+
+// Alerts returns a channel that receives every alarm the watermeter raises,
+// after AlertCooldown, alongside the per kind callbacks such as Stuck and
+// Idle.  The same channel is returned until the meter is closed or
+// initialized again, when it is closed.  Alarms are dropped rather than
+// delay updates if the channel is full.
+func (w *Watermeter) Alerts() <-chan Alert {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if nil == w.alertCh {
+		w.alertCh = make(chan Alert, alertBuffer)
+	}
+	return w.alertCh
+}
+
+// raise returns true if an alert of kind may be raised at now, sending it to
+// the Alerts channel if there is one.  The caller must hold the mutex.
+func (w *Watermeter) raise(kind AlertKind, now, since time.Time) bool {
+	if false == w.alertAllowed(kind, now) {
+		return false
+	}
+	if nil != w.alertCh {
+		select {
+		case w.alertCh <- Alert{Kind: kind, Time: now, Since: since}:
+		default:
+		}
+	}
+	return true
+}
+
+// alertAllowed returns true and starts the cooldown if an alert of kind may
+// be raised at now.  The caller must hold the mutex.
+func (w *Watermeter) alertAllowed(kind AlertKind, now time.Time) bool {
+	if 0 >= w.AlertCooldown {
+		return true
+	}
+	if last, ok := w.alerts[kind]; ok && now.Sub(last) < w.AlertCooldown {
+		return false
+	}
+	if nil == w.alerts {
+		w.alerts = make(map[AlertKind]time.Time)
+	}
+	w.alerts[kind] = now
+	return true
+}
@@ -0,0 +1,96 @@
+package watermeter
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestAlertCooldown(t *testing.T) {
+	assert := assert.New(t)
+
+	stuck := make(chan time.Time, 4)
+	wm := Watermeter{
+		Timeout:       time.Hour,
+		StuckTimeout:  time.Minute,
+		AlertCooldown: 10 * time.Minute,
+		Stuck:         func(since time.Time) { stuck <- since },
+	}
+	setNow(&wm, 0)
+	wm.Init(0)
+
+	// Three stuck episodes in quick succession, then one after the cooldown.
+	for _, min := range []int{0, 3, 6, 12} {
+		setNow(&wm, min)
+		wm.Update(100)
+		setNow(&wm, min+1)
+		wm.Update(0)
+	}
+
+	got := []time.Time{}
+	for i := 0; i < 2; i++ {
+		select {
+		case since := <-stuck:
+			got = append(got, since)
+		case <-time.After(time.Second):
+			assert.Fail("Stuck was not called")
+		}
+	}
+	assert.ElementsMatch([]time.Time{at(0, 0), at(12, 0)}, got)
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(0, len(stuck))
+}
+
+func TestAlertKindString(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("stuck", AlertStuck.String())
+	assert.Equal("idle", AlertIdle.String())
+	assert.Equal("unknown", AlertKind(-1).String())
+}
+
+func TestAlerts(t *testing.T) {
+	assert := assert.New(t)
+
+	wm := Watermeter{
+		Timeout:       time.Hour,
+		StuckTimeout:  time.Minute,
+		IdleTimeout:   5 * time.Minute,
+		AlertCooldown: 10 * time.Minute,
+	}
+	setNow(&wm, 0)
+	wm.Init(0)
+	alerts := wm.Alerts()
+	assert.Equal(alerts, wm.Alerts())
+
+	setNow(&wm, 1)
+	wm.Update(100)
+	setNow(&wm, 2)
+	wm.Update(0)
+	setNow(&wm, 3)
+	wm.Update(100)
+	setNow(&wm, 4)
+	wm.Update(0)
+
+	// Idle after the last update; the second stuck is in the cooldown.
+	setNow(&wm, 10)
+	wm.checkIdle()
+
+	got := []Alert{}
+	for i := 0; i < 2; i++ {
+		select {
+		case a := <-alerts:
+			got = append(got, a)
+		case <-time.After(time.Second):
+			assert.Fail("missing alert")
+		}
+	}
+	assert.Equal([]Alert{
+		{Kind: AlertStuck, Time: at(2, 0), Since: at(1, 0)},
+		{Kind: AlertIdle, Time: at(10, 0), Since: at(4, 0)},
+	}, got)
+
+	wm.Close()
+	_, ok := <-alerts
+	assert.False(ok)
+}
@@ -0,0 +1,123 @@
+package watermeter
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// historyVersion is the version of the MarshalHistory layout.
+const historyVersion = 1
+
+// ErrInvalidHistory is returned when history can't be decoded.
+var ErrInvalidHistory = errors.New("watermeter: invalid history")
+
+// MarshalHistory encodes the retained events compactly for long term
+// storage.  The layout is a version byte and the uvarint number of events,
+// then the oldest event as its varint Unix time in nanoseconds and uvarint
+// total, then each newer event as the uvarint nanoseconds and varint change
+// in total since the one before.  Tags are not kept.
+func (w *Watermeter) MarshalHistory() []byte {
+	w.mutex.Lock()
+	events := w.entries()
+	w.mutex.Unlock()
+
+	rv := make([]byte, 0, 1+binary.MaxVarintLen64*(1+2*len(events)))
+	rv = append(rv, historyVersion)
+	rv = binary.AppendUvarint(rv, uint64(len(events)))
+	for i, e := range events {
+		if 0 == i {
+			rv = binary.AppendVarint(rv, e.time.UnixNano())
+			rv = binary.AppendUvarint(rv, e.total)
+			continue
+		}
+		prev := events[i-1]
+		rv = binary.AppendUvarint(rv, uint64(e.time.Sub(prev.time)))
+		rv = binary.AppendVarint(rv, int64(e.total-prev.total))
+	}
+	return rv
+}
+
+// UnmarshalHistory initializes the watermeter from history encoded by
+// MarshalHistory.  The running total is that of the newest event; the
+// lifetime total is kept.  Events older than Timeout are pruned by the next
+// update.
+func (w *Watermeter) UnmarshalHistory(data []byte) error {
+	if 0 == len(data) || historyVersion != data[0] {
+		return ErrInvalidHistory
+	}
+	data = data[1:]
+
+	next := func() (uint64, bool) {
+		v, n := binary.Uvarint(data)
+		if 0 >= n {
+			return 0, false
+		}
+		data = data[n:]
+		return v, true
+	}
+	nextSigned := func() (int64, bool) {
+		v, n := binary.Varint(data)
+		if 0 >= n {
+			return 0, false
+		}
+		data = data[n:]
+		return v, true
+	}
+
+	count, ok := next()
+	if false == ok || 0 == count || uint64(len(data)) < count {
+		return ErrInvalidHistory
+	}
+	nanos, ok := nextSigned()
+	if false == ok {
+		return ErrInvalidHistory
+	}
+	total, ok := next()
+	if false == ok {
+		return ErrInvalidHistory
+	}
+
+	events := make([]entry, 1, count)
+	events[0] = entry{time: time.Unix(0, nanos), total: total}
+	for i := uint64(1); i < count; i++ {
+		span, ok := next()
+		if false == ok || 1<<63 <= span {
+			return ErrInvalidHistory
+		}
+		delta, ok := nextSigned()
+		if false == ok {
+			return ErrInvalidHistory
+		}
+		prev := events[i-1]
+		events = append(events, entry{
+			time:  prev.time.Add(time.Duration(span)),
+			total: prev.total + uint64(delta),
+		})
+	}
+	if 0 != len(data) {
+		return ErrInvalidHistory
+	}
+
+	if nil == w.now {
+		w.now = func() time.Time { return time.Now() }
+	}
+	w.initAt(events[0].total, events[0].time)
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	for _, e := range events[1:] {
+		n := w.newEntry()
+		*n = e
+		w.events.PushFront(n)
+	}
+	newest := events[len(events)-1]
+	w.total = newest.total
+	w.lastGallon = newest
+	w.seedGallon = 1 == len(events)
+	w.lastChange = newest.time
+	w.lastUpdate = newest.time
+
+	return nil
+}
@@ -0,0 +1,69 @@
+package watermeter
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestMarshalHistory(t *testing.T) {
+	assert := assert.New(t)
+
+	// A week of irregular pulses, one every few seconds to minutes.
+	r := rand.New(rand.NewSource(1))
+	clock := time.Date(2016, time.December, 25, 1, 0, 0, 0, time.UTC)
+	wm := Watermeter{
+		Timeout: 7 * 24 * time.Hour,
+		now:     func() time.Time { return clock },
+	}
+	wm.Init(123456789)
+	for i := 0; i < 2000; i++ {
+		clock = clock.Add(time.Duration(1+r.Intn(300)) * time.Second)
+		wm.Update(uint(100 + r.Intn(200)))
+	}
+
+	data := wm.MarshalHistory()
+
+	var restored Watermeter
+	restored.now = func() time.Time { return clock }
+	assert.Nil(restored.UnmarshalHistory(data))
+
+	want, got := wm.entries(), restored.entries()
+	assert.Equal(len(want), len(got))
+	for i := range want {
+		assert.True(want[i].time.Equal(got[i].time), "event %d", i)
+		assert.Equal(want[i].total, got[i].total, "event %d", i)
+	}
+	assert.Equal(wm.GetGallons(), restored.GetGallons())
+	assert.Equal(wm.GetFlow(time.Hour), restored.GetFlow(time.Hour))
+	assert.Nil(restored.Validate())
+
+	events := make([]Event, 0, len(want))
+	for _, e := range want {
+		events = append(events, Event{Time: e.time, Total: e.total})
+	}
+	js, _ := json.Marshal(events)
+	assert.Less(len(data)*5, len(js), "%d bytes vs %d of JSON", len(data), len(js))
+}
+
+func TestUnmarshalHistoryInvalid(t *testing.T) {
+	assert := assert.New(t)
+
+	wm := Watermeter{Timeout: time.Hour}
+	setNow(&wm, 0)
+	wm.Init(0)
+	setNow(&wm, 1)
+	wm.Update(1000)
+	data := wm.MarshalHistory()
+
+	var restored Watermeter
+	assert.Equal(ErrInvalidHistory, restored.UnmarshalHistory(nil))
+	assert.Equal(ErrInvalidHistory, restored.UnmarshalHistory(data[:len(data)-1]))
+	assert.Equal(ErrInvalidHistory, restored.UnmarshalHistory(append(data, 0)))
+	assert.Equal(ErrInvalidHistory, restored.UnmarshalHistory([]byte{historyVersion, 0}))
+
+	data[0] = historyVersion + 1
+	assert.Equal(ErrInvalidHistory, restored.UnmarshalHistory(data))
+}
@@ -0,0 +1,37 @@
+package watermeter
+
+import (
+	"math"
+)
+
+// CalibrationError compares the running total with a trusted reference
+// reading in gallons taken at the same instant, returning the error as a
+// fraction of the reference: positive when the meter reads high.  It returns
+// 0 if the reference isn't positive.
+func (w *Watermeter) CalibrationError(referenceGallons float64) (errorFraction float64) {
+	if false == (0 < referenceGallons) {
+		return 0
+	}
+
+	w.mutex.Lock()
+	total := w.total
+	w.mutex.Unlock()
+
+	return (float64(total)/1000 - referenceGallons) / referenceGallons
+}
+
+// SuggestUnitsPerGallon gets the UnitsPerGallon that would have made the
+// pulses counted by UpdatePulses read the reference reading in gallons,
+// rounded to the nearest unit.  It returns 0 if no pulses were counted or the
+// reference isn't positive.
+func (w *Watermeter) SuggestUnitsPerGallon(referenceGallons float64) uint64 {
+	if false == (0 < referenceGallons) {
+		return 0
+	}
+
+	w.mutex.Lock()
+	pulses := w.pulses
+	w.mutex.Unlock()
+
+	return uint64(math.Round(float64(pulses) / referenceGallons))
+}
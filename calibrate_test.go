@@ -0,0 +1,36 @@
+package watermeter
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestCalibration(t *testing.T) {
+	assert := assert.New(t)
+
+	wm := Watermeter{Timeout: time.Hour, UnitsPerGallon: 450}
+	setNow(&wm, 0)
+	wm.Init(0)
+
+	// 4500 pulses read as 10 gallons, but the reference saw 9.
+	for min := 1; min <= 9; min++ {
+		setNow(&wm, min)
+		wm.UpdatePulses(500)
+	}
+	assert.Equal(uint64(4500), wm.GetPulses())
+	assert.Equal(uint64(10000), wm.total)
+
+	assert.InDelta(1.0/9, wm.CalibrationError(9), 1e-9)
+	assert.Equal(uint64(500), wm.SuggestUnitsPerGallon(9))
+
+	// The suggestion makes the meter match the reference.
+	assert.Nil(wm.Reconfigure(WithUnitsPerGallon(wm.SuggestUnitsPerGallon(9))))
+	wm.Reset()
+	wm.UpdatePulses(4500)
+	assert.Equal(uint64(9000), wm.total)
+	assert.Equal(0.0, wm.CalibrationError(9))
+
+	assert.Equal(0.0, wm.CalibrationError(0))
+	assert.Equal(uint64(0), wm.SuggestUnitsPerGallon(-1))
+}
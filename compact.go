@@ -0,0 +1,118 @@
+package watermeter
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// compactVersion is the version of the compact state layout.  Version 1
+// had no lifetime total.
+const compactVersion = 2
+
+// compactHeader is the size of the compact state before the name.
+const compactHeader = 1 + 8 + 8 + 8 + 2
+
+// compactHeaderV1 is the size of version 1 compact state before the name.
+const compactHeaderV1 = 1 + 8 + 8 + 2
+
+// ErrInvalidCompact is returned when compact state can't be decoded.
+var ErrInvalidCompact = errors.New("watermeter: invalid compact state")
+
+// MarshalCompact encodes just enough of the watermeter to resume its running
+// total: the total, the lifetime total, the time of the last update and the
+// Name.  The layout is a version byte, the big endian total and lifetime
+// total, the big endian Unix time in nanoseconds, then the big endian name
+// length and the name.
+func (w *Watermeter) MarshalCompact() []byte {
+	w.mutex.Lock()
+	total := w.total
+	lifetime := w.lifetime
+	last := w.lastUpdate
+	name := w.Name
+	w.mutex.Unlock()
+
+	if 0xffff < len(name) {
+		name = name[:0xffff]
+	}
+
+	rv := make([]byte, compactHeader+len(name))
+	rv[0] = compactVersion
+	binary.BigEndian.PutUint64(rv[1:], total)
+	binary.BigEndian.PutUint64(rv[9:], lifetime)
+	binary.BigEndian.PutUint64(rv[17:], uint64(last.UnixNano()))
+	binary.BigEndian.PutUint16(rv[25:], uint16(len(name)))
+	copy(rv[compactHeader:], name)
+
+	return rv
+}
+
+// UnmarshalCompact initializes the watermeter from state encoded by
+// MarshalCompact.  The history starts with a single event at the stored
+// total and time, so the running total is preserved but the flow starts
+// fresh.  Version 1 state is still accepted and restores a lifetime total of
+// 0.
+func (w *Watermeter) UnmarshalCompact(data []byte) error {
+	if 0 == len(data) {
+		return ErrInvalidCompact
+	}
+
+	var total, lifetime uint64
+	var header int
+	switch data[0] {
+	case 1:
+		header = compactHeaderV1
+		if header > len(data) {
+			return ErrInvalidCompact
+		}
+		total = binary.BigEndian.Uint64(data[1:])
+	case compactVersion:
+		header = compactHeader
+		if header > len(data) {
+			return ErrInvalidCompact
+		}
+		total = binary.BigEndian.Uint64(data[1:])
+		lifetime = binary.BigEndian.Uint64(data[9:])
+	default:
+		return ErrInvalidCompact
+	}
+	last := time.Unix(0, int64(binary.BigEndian.Uint64(data[header-10:])))
+	size := int(binary.BigEndian.Uint16(data[header-2:]))
+	if header+size != len(data) {
+		return ErrInvalidCompact
+	}
+
+	if nil == w.now {
+		w.now = func() time.Time { return time.Now() }
+	}
+	w.Name = string(data[header:])
+	w.initAt(total, last)
+	w.mutex.Lock()
+	w.lifetime = lifetime
+	w.mutex.Unlock()
+
+	return nil
+}
+
+// ReconcileUsage calls Usage once for each boundary crossed between
+// previousTotal and the current total, both in 1/1000 gallon units, so a
+// consumer counting Usage calls catches up after Restore jumps the total
+// forward.  The calls are made in order from a single dispatch with a flow of
+// 0 since the timing of the missed volume isn't known.  Nothing is called if
+// the total hasn't passed a boundary since previousTotal.
+func (w *Watermeter) ReconcileUsage(previousTotal uint64) {
+	w.mutex.Lock()
+	increment := w.usageIncrement()
+	from, to := previousTotal/increment, w.total/increment
+	usage := w.Usage
+	w.mutex.Unlock()
+
+	if nil == usage || to <= from {
+		return
+	}
+	w.dispatch(func() {
+		for gallons := from + 1; gallons <= to; gallons++ {
+			usage(gallons, 0)
+		}
+	})
+}
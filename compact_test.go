@@ -0,0 +1,117 @@
+package watermeter
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestCompactRoundTrip(t *testing.T) {
+	assert := assert.New(t)
+
+	wm := Watermeter{Name: "garden", Timeout: time.Hour}
+	setNow(&wm, 0)
+	wm.Init(1500)
+	setNow(&wm, 1)
+	wm.Update(2000)
+	setNow(&wm, 2)
+	wm.Update(250)
+
+	data := wm.MarshalCompact()
+	assert.Equal(compactHeader+len("garden"), len(data))
+	assert.Equal(byte(compactVersion), data[0])
+
+	restored := Watermeter{Timeout: time.Hour}
+	setNow(&restored, 3)
+	assert.Nil(restored.UnmarshalCompact(data))
+
+	assert.Equal("garden", restored.Name)
+	assert.Equal(uint64(3), restored.GetGallons())
+	assert.Equal(uint64(3750), restored.TotalAt(at(2, 0)))
+	assert.Equal(1, restored.events.Len())
+	assert.True(time.Date(2016, time.December, 25, 1, 2, 0, 0, time.UTC).Equal(restored.events.Front().Value.(*entry).time))
+	assert.Equal(0.0, restored.GetFlow(time.Minute))
+}
+
+func TestCompactInvalid(t *testing.T) {
+	assert := assert.New(t)
+
+	wm := Watermeter{Timeout: time.Hour}
+	setNow(&wm, 0)
+	wm.Init(0)
+	data := wm.MarshalCompact()
+
+	var restored Watermeter
+	assert.Equal(ErrInvalidCompact, restored.UnmarshalCompact(nil))
+	assert.Equal(ErrInvalidCompact, restored.UnmarshalCompact(data[:compactHeader-1]))
+	assert.Equal(ErrInvalidCompact, restored.UnmarshalCompact(append(data, 'x')))
+
+	data[0] = compactVersion + 1
+	assert.Equal(ErrInvalidCompact, restored.UnmarshalCompact(data))
+}
+
+func TestCompactLifetime(t *testing.T) {
+	assert := assert.New(t)
+
+	wm := Watermeter{Name: "main", Timeout: time.Hour}
+	setNow(&wm, 0)
+	wm.Init(0)
+	setNow(&wm, 1)
+	wm.Update(4000)
+	wm.Reset()
+	setNow(&wm, 2)
+	wm.Update(1000)
+
+	var restored Watermeter
+	setNow(&restored, 3)
+	assert.Nil(restored.UnmarshalCompact(wm.MarshalCompact()))
+	assert.Equal(uint64(1), restored.GetGallons())
+	assert.Equal(uint64(5), restored.GetLifetimeGallons())
+
+	// Version 1 state has no lifetime total.
+	v1 := []byte{1, 0, 0, 0, 0, 0, 0, 0x0b, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2, 'h', 'i'}
+	assert.Nil(restored.UnmarshalCompact(v1))
+	assert.Equal("hi", restored.Name)
+	assert.Equal(uint64(3), restored.GetGallons())
+	assert.Equal(uint64(0), restored.GetLifetimeGallons())
+	assert.Equal(ErrInvalidCompact, restored.UnmarshalCompact(v1[:compactHeaderV1-1]))
+}
+
+func TestReconcileUsage(t *testing.T) {
+	assert := assert.New(t)
+
+	// The device counted 5 gallons while we were down.
+	device := Watermeter{Timeout: time.Hour}
+	setNow(&device, 0)
+	device.Init(10250)
+	setNow(&device, 30)
+	device.Update(5000)
+
+	calls := make(chan uint64, 10)
+	restored, err := Restore(device.MarshalCompact(),
+		WithUsage(func(gallons uint64, flow float64) {
+			assert.Equal(0.0, flow)
+			calls <- gallons
+		}),
+	)
+	assert.Nil(err)
+	restored.ReconcileUsage(10250)
+
+	for _, want := range []uint64{11, 12, 13, 14, 15} {
+		select {
+		case got := <-calls:
+			assert.Equal(want, got)
+		case <-time.After(time.Second):
+			assert.Fail("missing usage", "gallons %d", want)
+			return
+		}
+	}
+
+	// Nothing was crossed.
+	restored.ReconcileUsage(15000)
+	select {
+	case got := <-calls:
+		assert.Fail("unexpected usage", "gallons %d", got)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
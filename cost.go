@@ -0,0 +1,47 @@
+package watermeter
+
+import (
+	"time"
+)
+
+// A Tier is one step of a tiered water rate table.
+type Tier struct {
+	// UpTo is the volume in gallons, counted from the start of the period,
+	// the rate applies up to.  Zero means no limit.  The last tier has no
+	// limit whatever its UpTo.
+	UpTo float64
+
+	// Rate is the cost per gallon.
+	Rate float64
+}
+
+// EstimateCost gets the cost of the volume in gallons under the tiers, which
+// must be ordered by UpTo.  Each tier prices the volume between the previous
+// tier's UpTo and its own, and the last tier also prices any volume past its
+// UpTo.
+func EstimateCost(tiers []Tier, gallons float64) float64 {
+	var cost, from float64
+	for i, tier := range tiers {
+		if gallons <= from {
+			break
+		}
+		to := gallons
+		if 0 < tier.UpTo && tier.UpTo < to && i < len(tiers)-1 {
+			to = tier.UpTo
+		}
+		cost += (to - from) * tier.Rate
+		from = to
+	}
+	return cost
+}
+
+// CostDelta gets how much more periodB cost than periodA under the tiers,
+// each period running from its first time to its second and priced as a
+// bill of its own, for an alert that the bill is trending higher.  The
+// volumes are from GetVolumeRange, so a period reaching outside the retained
+// history is clamped to it and is underpriced by the volume it misses.
+func (w *Watermeter) CostDelta(tiers []Tier, periodA, periodB [2]time.Time) float64 {
+	a := float64(w.GetVolumeRange(periodA[0], periodA[1])) / 1000
+	b := float64(w.GetVolumeRange(periodB[0], periodB[1])) / 1000
+	return EstimateCost(tiers, b) - EstimateCost(tiers, a)
+}
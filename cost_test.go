@@ -0,0 +1,46 @@
+package watermeter
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestEstimateCost(t *testing.T) {
+	assert := assert.New(t)
+
+	tiers := []Tier{{UpTo: 10, Rate: 0.01}, {UpTo: 30, Rate: 0.02}, {Rate: 0.05}}
+	assert.Equal(0.0, EstimateCost(tiers, 0))
+	assert.InDelta(0.05, EstimateCost(tiers, 5), 1e-9)
+	assert.InDelta(0.3, EstimateCost(tiers, 20), 1e-9)
+	assert.InDelta(1.0, EstimateCost(tiers, 40), 1e-9)
+
+	// Past a bounded last tier the volume is priced at its rate.
+	assert.InDelta(0.4, EstimateCost(tiers[:1], 40), 1e-9)
+	assert.InDelta(0.7, EstimateCost(tiers[:2], 40), 1e-9)
+	assert.Equal(0.0, EstimateCost(nil, 40))
+}
+
+func TestCostDelta(t *testing.T) {
+	assert := assert.New(t)
+
+	wm := Watermeter{Timeout: time.Hour}
+	setNow(&wm, 0)
+	wm.Init(0)
+
+	// 5 gallons in the first ten minutes, 40 in the next.
+	setNow(&wm, 10)
+	wm.Update(5000)
+	setNow(&wm, 20)
+	wm.Update(40000)
+
+	tiers := []Tier{{UpTo: 10, Rate: 0.01}, {UpTo: 30, Rate: 0.02}, {Rate: 0.05}}
+	a := [2]time.Time{at(0, 0), at(10, 0)}
+	b := [2]time.Time{at(10, 0), at(20, 0)}
+	assert.InDelta(0.95, wm.CostDelta(tiers, a, b), 1e-9)
+	assert.InDelta(-0.95, wm.CostDelta(tiers, b, a), 1e-9)
+
+	// A period past the history is clamped to it.
+	c := [2]time.Time{at(10, 0), at(50, 0)}
+	assert.InDelta(0.0, wm.CostDelta(tiers, b, c), 1e-9)
+}
@@ -0,0 +1,35 @@
+package watermeter
+
+import (
+	"errors"
+)
+
+// ErrCounterReset is reported when an absolute counter reading goes
+// backwards.
+var ErrCounterReset = errors.New("watermeter: absolute counter reset")
+
+// UpdateAbsoluteCounter updates the watermeter from a monotonic hardware
+// counter of 1/1000 gallons rather than from a delta.  Only the difference
+// from the previous reading is applied.  The first reading seeds the counter
+// without changing the total, and a reading lower than the previous one is
+// treated as a counter reset: it is reported to OnError and seeds the counter
+// again.
+func (w *Watermeter) UpdateAbsoluteCounter(reading uint64) {
+	w.ensureInit()
+	w.mutex.Lock()
+	seeded := w.counterSeeded
+	last := w.counterReading
+	w.counterSeeded = true
+	w.counterReading = reading
+	w.mutex.Unlock()
+
+	if false == seeded {
+		return
+	}
+	if reading < last {
+		w.reject(ErrCounterReset)
+		return
+	}
+
+	w.update(w.now(), int64(reading-last), "")
+}
@@ -0,0 +1,48 @@
+package watermeter
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestUpdateAbsoluteCounter(t *testing.T) {
+	assert := assert.New(t)
+
+	errs := make(chan error, 1)
+	wm := Watermeter{
+		Timeout: time.Hour,
+		OnError: func(err error) { errs <- err },
+	}
+	setNow(&wm, 0)
+	wm.Init(0)
+
+	setNow(&wm, 1)
+	wm.UpdateAbsoluteCounter(123000)
+	assert.Equal(uint64(0), wm.GetGallons())
+	assert.Equal(1, wm.events.Len())
+
+	setNow(&wm, 2)
+	wm.UpdateAbsoluteCounter(124000)
+	setNow(&wm, 3)
+	wm.UpdateAbsoluteCounter(124500)
+	setNow(&wm, 4)
+	wm.UpdateAbsoluteCounter(126000)
+	assert.Equal(uint64(3000), wm.TotalAt(at(4, 0)))
+	assert.Equal(1.5, wm.GetFlow(time.Minute))
+
+	// The counter resets and is seeded again.
+	setNow(&wm, 5)
+	wm.UpdateAbsoluteCounter(200)
+	select {
+	case err := <-errs:
+		assert.Equal(ErrCounterReset, err)
+	case <-time.After(time.Second):
+		assert.Fail("OnError was not called")
+	}
+	assert.Equal(uint64(3000), wm.TotalAt(at(5, 0)))
+
+	setNow(&wm, 6)
+	wm.UpdateAbsoluteCounter(1200)
+	assert.Equal(uint64(4), wm.GetGallons())
+}
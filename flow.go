@@ -0,0 +1,167 @@
+package watermeter
+
+import (
+	"math"
+	"time"
+)
+
+// rate returns the flow rate (gallons/min) of delta 1/1000 gallon units over
+// span, or 0 for an empty span.  delta is the difference of two totals, so a
+// total that went down (see UpdateSigned) wraps and gives a negative rate.
+func rate(delta uint64, span time.Duration) float64 {
+	if 0 >= span {
+		return 0
+	}
+	return float64(int64(delta)) / 1000 / span.Minutes()
+}
+
+// FlowAcceleration gets the rate of change of the flow rate (gallons/min per
+// minute) over the specified window.  The flow over the older half of the
+// window is compared to the flow over the newer half, so a positive value
+// means the flow is ramping up and a negative value means it is ramping down.
+func (w *Watermeter) FlowAcceleration(window time.Duration) float64 {
+	half := window / 2
+	if 0 >= half {
+		return 0
+	}
+
+	now := w.now()
+	w.mutex.Lock()
+	events := w.entries()
+	w.mutex.Unlock()
+
+	start := interpolate(events, now.Add(-window))
+	mid := interpolate(events, now.Add(-half))
+	end := interpolate(events, now)
+
+	return (rate(end-mid, half) - rate(mid-start, half)) / half.Minutes()
+}
+
+// GetFlowSamples gets the flow rate (gallons/min) over the newest n
+// intervals between events rather than over a duration, which is robust to
+// irregular spacing.  n is clamped to the retained events and 0 is returned
+// if there are fewer than 2 events.
+func (w *Watermeter) GetFlowSamples(n int) float64 {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if 1 > n || 2 > w.events.Len() {
+		return 0
+	}
+
+	newest := w.events.Front()
+	oldest := newest
+	for i := 0; i < n && nil != oldest.Next(); i++ {
+		oldest = oldest.Next()
+	}
+
+	end := newest.Value.(*entry)
+	start := oldest.Value.(*entry)
+	return rate(end.total-start.total, end.time.Sub(start.time))
+}
+
+// reportedFlow caps the flow rate at MaxReportedFlow.
+func (w *Watermeter) reportedFlow(flow float64) float64 {
+	if 0 < w.MaxReportedFlow && w.MaxReportedFlow < flow {
+		return w.MaxReportedFlow
+	}
+	return flow
+}
+
+// InstantFlow gets the flow rate (gallons/min) over the newest interval
+// between events, capped at MaxReportedFlow.
+func (w *Watermeter) InstantFlow() float64 {
+	return w.reportedFlow(w.GetFlowSamples(1))
+}
+
+// GetFlowStable gets the flow rate (gallons/min) over the specified duration
+// ending at the newest event rather than now.  Only completed intervals
+// between events are used, so the value doesn't drift while the current
+// gallon fills, at the cost of lagging GetFlow by the time since the newest
+// event.
+func (w *Watermeter) GetFlowStable(duration time.Duration) float64 {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.flow(w.events.Front().Value.(*entry).time, duration)
+}
+
+// GetFlowWithSpan gets the same flow rate (gallons/min) as GetFlow along with
+// the time it was actually measured over and the number of events backing
+// it.  An actual span shorter than the duration means the history didn't
+// cover the whole duration and the flow is understated.
+func (w *Watermeter) GetFlowWithSpan(duration time.Duration) (flow float64, actualSpan time.Duration, samples int) {
+	now := w.now()
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.flowSpan(now, duration)
+}
+
+// confidenceSamples is the number of events within a window at which
+// FlowConfidence considers the window densely sampled.
+const confidenceSamples = 10
+
+// FlowConfidence scores how trustworthy GetFlow is over the specified
+// duration from 0 to 1.  The score is the fraction of the duration the
+// events within it actually span times the number of those events over
+// confidenceSamples, each capped at 1.  A flow from a couple of events far
+// apart, or from a history shorter than the duration, scores low.
+func (w *Watermeter) FlowConfidence(duration time.Duration) float64 {
+	if 0 >= duration {
+		return 0
+	}
+
+	_, span, samples := w.GetFlowWithSpan(duration)
+
+	coverage := math.Min(1, float64(span)/float64(duration))
+	density := math.Min(1, float64(samples)/confidenceSamples)
+	return coverage * density
+}
+
+// GetFlowTrapezoidal gets the average flow rate (gallons/min) over the
+// specified duration by trapezoidal integration of the instantaneous rate.
+// The instantaneous rate at each event within the duration is the rate over
+// the interval ending at it, and the rate between events is interpolated
+// linearly.  The average is taken over the time the rated events span rather
+// than the whole duration.
+//
+// GetFlow divides the volume between the events bounding the duration by the
+// duration, so it depends on exactly where those events fall.  This instead
+// weights each rate by the time around it, so it differs from GetFlow when
+// the events are unevenly spaced and the rate is changing or the history
+// doesn't cover the duration.  It returns 0 until there are two rated events
+// within the duration.
+func (w *Watermeter) GetFlowTrapezoidal(duration time.Duration) float64 {
+	if 0 >= duration {
+		return 0
+	}
+	then := w.now().Add(-duration)
+
+	w.mutex.Lock()
+	events := w.entries()
+	w.mutex.Unlock()
+
+	type point struct {
+		time time.Time
+		rate float64
+	}
+	var points []point
+	for i := 1; i < len(events); i++ {
+		a, b := events[i-1], events[i]
+		if b.time.Before(then) || false == b.time.After(a.time) {
+			continue
+		}
+		points = append(points, point{b.time, rate(b.total-a.total, b.time.Sub(a.time))})
+	}
+	if 2 > len(points) {
+		return 0
+	}
+
+	var area float64
+	for i := 1; i < len(points); i++ {
+		area += (points[i-1].rate + points[i].rate) / 2 * points[i].time.Sub(points[i-1].time).Minutes()
+	}
+	return area / points[len(points)-1].time.Sub(points[0].time).Minutes()
+}
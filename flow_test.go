@@ -0,0 +1,232 @@
+package watermeter
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFlowAcceleration(t *testing.T) {
+	assert := assert.New(t)
+
+	up := Watermeter{Timeout: time.Hour}
+	down := Watermeter{Timeout: time.Hour}
+	setNow(&up, 0)
+	setNow(&down, 0)
+	up.Init(0)
+	down.Init(0)
+
+	for min := 1; min <= 4; min++ {
+		setNow(&up, min)
+		up.Update(uint(250 * min))
+		setNow(&down, min)
+		down.Update(uint(1250 - 250*min))
+	}
+
+	assert.Equal(0.25, up.FlowAcceleration(4*time.Minute))
+	assert.Equal(-0.25, down.FlowAcceleration(4*time.Minute))
+	assert.Equal(0.0, up.FlowAcceleration(0))
+	assert.Equal(0.0, up.FlowAcceleration(time.Nanosecond))
+}
+
+func TestGetFlowSamples(t *testing.T) {
+	assert := assert.New(t)
+
+	wm := Watermeter{Timeout: time.Hour}
+	setNow(&wm, 0)
+	wm.Init(0)
+	assert.Equal(0.0, wm.GetFlowSamples(3))
+
+	setNow(&wm, 1)
+	wm.Update(1000)
+	setNow(&wm, 5)
+	wm.Update(1000)
+	setNow(&wm, 6)
+	wm.Update(500)
+	setNow(&wm, 8)
+	wm.Update(1500)
+
+	assert.Equal(0.75, wm.GetFlowSamples(1))
+	assert.Equal(2.0/3, wm.GetFlowSamples(2))
+	assert.Equal(3.0/7, wm.GetFlowSamples(3))
+	assert.Equal(0.5, wm.GetFlowSamples(4))
+	assert.Equal(0.5, wm.GetFlowSamples(40))
+	assert.Equal(0.0, wm.GetFlowSamples(0))
+}
+
+func TestMaxReportedFlow(t *testing.T) {
+	var wg sync.WaitGroup
+
+	assert := assert.New(t)
+
+	wm := Watermeter{Timeout: time.Hour, MaxReportedFlow: 20}
+	wm.now = func() time.Time { return at(0, 0) }
+	wm.Init(0)
+
+	wm.now = func() time.Time { return at(1, 0) }
+	setUsage(assert, &wm, &wg, 1, 1.0)
+	wm.Update(1000)
+	wg.Wait()
+	assert.Equal(1.0, wm.InstantFlow())
+
+	// A double fire 100ms later is 600 gallons/min raw.
+	wm.now = func() time.Time { return at(1, 0).Add(100 * time.Millisecond) }
+	setUsage(assert, &wm, &wg, 2, 20)
+	wm.Update(1000)
+	wg.Wait()
+
+	assert.Equal(20.0, wm.InstantFlow())
+	assert.Equal(600.0, wm.GetFlowSamples(1))
+	assert.Equal(uint64(2), wm.GetGallons())
+}
+
+func TestIdenticalTimestamps(t *testing.T) {
+	assert := assert.New(t)
+
+	flows := make(chan float64, 4)
+	wm := Watermeter{
+		Timeout: time.Hour,
+		Usage:   func(gallons uint64, flow float64) { flows <- flow },
+	}
+	setNow(&wm, 0)
+	wm.Init(0)
+
+	// Coarse clock: every update lands on the same instant.
+	wm.Update(1000)
+	wm.Update(1000)
+	wm.Update(1000)
+
+	for i := 0; i < 3; i++ {
+		select {
+		case flow := <-flows:
+			assert.False(math.IsInf(flow, 0) || math.IsNaN(flow))
+			assert.Equal(0.0, flow)
+		case <-time.After(time.Second):
+			assert.Fail("Usage was not called")
+		}
+	}
+
+	for _, f := range []float64{
+		wm.InstantFlow(),
+		wm.GetFlowSamples(3),
+		wm.GetFlow(0),
+		wm.FlowAcceleration(0),
+		wm.FlowMedian(time.Minute),
+		wm.FlowCV(time.Minute),
+	} {
+		assert.Equal(0.0, f)
+	}
+	assert.Equal(3.0, wm.GetFlow(time.Minute))
+}
+
+func TestGetFlowStable(t *testing.T) {
+	assert := assert.New(t)
+
+	wm := Watermeter{Timeout: time.Hour}
+	setNow(&wm, 0)
+	wm.Init(0)
+	for min := 1; min <= 4; min++ {
+		setNow(&wm, min)
+		wm.Update(1000)
+	}
+	assert.Equal(1.0, wm.GetFlow(2*time.Minute))
+	assert.Equal(1.0, wm.GetFlowStable(2*time.Minute))
+
+	// Part way into the next interval the partial interval drags GetFlow
+	// down while the stable flow holds.
+	wm.now = func() time.Time { return at(4, 30) }
+	assert.Equal(0.75, wm.GetFlow(2*time.Minute))
+	assert.Equal(1.0, wm.GetFlowStable(2*time.Minute))
+	assert.Equal(0.0, wm.GetFlowStable(0))
+}
+
+func TestGetFlowWithSpan(t *testing.T) {
+	assert := assert.New(t)
+
+	wm := Watermeter{Timeout: time.Hour}
+	setNow(&wm, 2)
+	wm.Init(0)
+	setNow(&wm, 3)
+	wm.Update(1000)
+	setNow(&wm, 4)
+	wm.Update(1000)
+	setNow(&wm, 5)
+	wm.Update(1000)
+
+	// Only 3 of the 5 requested minutes exist.
+	flow, span, samples := wm.GetFlowWithSpan(5 * time.Minute)
+	assert.Equal(wm.GetFlow(5*time.Minute), flow)
+	assert.Equal(0.6, flow)
+	assert.Equal(3*time.Minute, span)
+	assert.Equal(4, samples)
+
+	// The start is interpolated half way into the interval before 4.
+	flow, span, samples = wm.GetFlowWithSpan(90 * time.Second)
+	assert.Equal(1.0, flow)
+	assert.Equal(90*time.Second, span)
+	assert.Equal(2, samples)
+
+	flow, span, samples = wm.GetFlowWithSpan(0)
+	assert.Equal(0.0, flow)
+	assert.Equal(time.Duration(0), span)
+	assert.Equal(1, samples)
+}
+
+func TestFlowConfidence(t *testing.T) {
+	assert := assert.New(t)
+
+	// Two events covering only the end of the window.
+	sparse := Watermeter{Timeout: time.Hour}
+	setNow(&sparse, 8)
+	sparse.Init(0)
+	setNow(&sparse, 9)
+	sparse.Update(1000)
+	setNow(&sparse, 10)
+	assert.InDelta(0.2*0.2, sparse.FlowConfidence(10*time.Minute), 1e-9)
+
+	// An event every 30 seconds over the whole window.
+	clock := time.Date(2016, time.December, 25, 1, 0, 0, 0, time.UTC)
+	dense := Watermeter{
+		Timeout: time.Hour,
+		now:     func() time.Time { return clock },
+	}
+	dense.Init(0)
+	for i := 0; i < 20; i++ {
+		clock = clock.Add(30 * time.Second)
+		dense.Update(500)
+	}
+	assert.Equal(1.0, dense.FlowConfidence(10*time.Minute))
+	assert.Less(sparse.FlowConfidence(10*time.Minute), dense.FlowConfidence(10*time.Minute))
+
+	assert.Equal(0.0, dense.FlowConfidence(0))
+}
+
+func TestGetFlowTrapezoidal(t *testing.T) {
+	assert := assert.New(t)
+
+	wm := Watermeter{Timeout: time.Hour}
+	setNow(&wm, 0)
+	wm.Init(0)
+	for _, u := range []struct {
+		t time.Time
+		m uint
+	}{
+		{at(1, 0), 1000},  // 1 gpm
+		{at(1, 30), 1000}, // 2 gpm
+		{at(4, 0), 2500},  // 1 gpm
+	} {
+		wm.now = func() time.Time { return u.t }
+		wm.Update(u.m)
+	}
+
+	// (1+2)/2*0.5 + (2+1)/2*2.5 gallons over the 3 minutes rated.
+	assert.InDelta(1.5, wm.GetFlowTrapezoidal(4*time.Minute), 1e-9)
+	// 4.5 gallons over the 4 minutes requested.
+	assert.InDelta(1.125, wm.GetFlow(4*time.Minute), 1e-9)
+
+	// Only one rated event.
+	assert.Equal(0.0, wm.GetFlowTrapezoidal(time.Minute))
+	assert.Equal(0.0, wm.GetFlowTrapezoidal(0))
+}
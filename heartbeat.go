@@ -0,0 +1,41 @@
+package watermeter
+
+import (
+	"time"
+)
+
+// watchHeartbeat adds a heartbeat every HeartbeatInterval until stop is
+// closed.
+func (w *Watermeter) watchHeartbeat(stop <-chan struct{}) {
+	ticker := time.NewTicker(w.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			w.heartbeat()
+		}
+	}
+}
+
+// heartbeat adds a heartbeat event at the current time and total if no event
+// has been added for HeartbeatInterval.
+func (w *Watermeter) heartbeat() {
+	now := w.now()
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.Lite || now.Sub(w.events.Front().Value.(*entry).time) < w.HeartbeatInterval {
+		return
+	}
+
+	e := w.newEntry()
+	e.time = now
+	e.total = w.total
+	e.heartbeat = true
+	w.events.PushFront(e)
+	w.pruneEvents(now.Add(-w.Timeout))
+}
@@ -0,0 +1,97 @@
+package watermeter
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestHeartbeat(t *testing.T) {
+	assert := assert.New(t)
+
+	wm := Watermeter{Timeout: time.Hour, HeartbeatInterval: time.Minute}
+	setNow(&wm, 0)
+	wm.Init(0)
+	wm.Close()
+
+	setNow(&wm, 1)
+	wm.Update(2000)
+
+	// Idle for five minutes, checked more often than the interval.  The
+	// flow reads 0 once the update is a minute old.
+	for sec := 90; sec <= 6*60; sec += 30 {
+		wm.now = func() time.Time { return at(0, 0).Add(time.Duration(sec) * time.Second) }
+		wm.heartbeat()
+		if 120 <= sec {
+			assert.Equal(0.0, wm.GetFlow(time.Minute))
+		}
+	}
+	assert.Equal(uint64(2000), wm.total)
+
+	setNow(&wm, 7)
+	wm.Update(500)
+
+	events := wm.DrainEvents()
+	assert.Equal(8, len(events))
+	for i, e := range events[2:7] {
+		assert.True(e.Heartbeat)
+		assert.Equal(at(i+2, 0), e.Time)
+		assert.Equal(uint64(2000), e.Total)
+	}
+	assert.False(events[1].Heartbeat)
+	assert.False(events[7].Heartbeat)
+}
+
+func TestHeartbeatTimer(t *testing.T) {
+	assert := assert.New(t)
+
+	wm := Watermeter{Timeout: time.Hour, HeartbeatInterval: 10 * time.Millisecond}
+	wm.Init(0)
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Nil(wm.Close())
+
+	n := len(wm.DrainEvents())
+	assert.True(3 <= n, "events: %d", n)
+
+	// Nothing is added once the meter is closed.
+	time.Sleep(30 * time.Millisecond)
+	assert.Equal(1, len(wm.DrainEvents()))
+}
+
+func TestHeartbeatIdle(t *testing.T) {
+	assert := assert.New(t)
+
+	idle := make(chan time.Time, 1)
+	wm := Watermeter{
+		Timeout:           time.Minute,
+		HeartbeatInterval: 20 * time.Millisecond,
+		IdleTimeout:       100 * time.Millisecond,
+		IdleCheckInterval: 20 * time.Millisecond,
+		Idle:              func(since time.Time) { idle <- since },
+	}
+	wm.Init(0)
+	defer wm.Close()
+
+	wm.Update(100)
+	last := wm.GetLastUpdate()
+
+	// Heartbeats aren't updates, so the meter still goes idle.
+	select {
+	case since := <-idle:
+		assert.Equal(last, since)
+	case <-time.After(time.Second):
+		assert.Fail("Idle was not called")
+	}
+
+	assert.Equal(last, wm.GetLastUpdate())
+	assert.Equal(last, wm.Snapshot().LastUpdate)
+	events := wm.DrainEvents()
+	assert.True(events[len(events)-1].Heartbeat)
+	assert.True(events[len(events)-1].Time.After(last))
+
+	restored := new(Watermeter)
+	assert.Nil(restored.UnmarshalCompact(wm.MarshalCompact()))
+	assert.True(last.Equal(restored.GetLastUpdate()))
+	restored.Close()
+}
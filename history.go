@@ -0,0 +1,318 @@
+package watermeter
+
+import (
+	"sort"
+	"time"
+)
+
+// entries returns a copy of the retained events, oldest first.  The caller
+// must hold the mutex.
+func (w *Watermeter) entries() []entry {
+	rv := make([]entry, w.events.Len())
+	i := len(rv) - 1
+	for item := w.events.Front(); nil != item; item = item.Next() {
+		rv[i] = *item.Value.(*entry)
+		i--
+	}
+	return rv
+}
+
+// interpolate returns the running total at time t by linear interpolation
+// between the oldest-first events surrounding it.  Times outside the events
+// are clamped to the oldest or newest total, and a time shared by several
+// events gets the newest of their totals.
+func interpolate(events []entry, t time.Time) uint64 {
+	// i is the first event after t.
+	i := sort.Search(len(events), func(i int) bool { return events[i].time.After(t) })
+	if 0 == len(events) {
+		return 0
+	}
+	if 0 == i {
+		return events[0].total
+	}
+	if len(events) == i {
+		return events[i-1].total
+	}
+
+	a, b := events[i-1], events[i]
+	fraction := float64(t.Sub(a.time)) / float64(b.time.Sub(a.time))
+	return a.total + uint64(int64(float64(int64(b.total-a.total))*fraction))
+}
+
+// TotalAt returns the running total in 1/1000 gallon units at the specified
+// time, interpolated between the retained events.  Times outside the
+// retained history are clamped to it.
+func (w *Watermeter) TotalAt(t time.Time) uint64 {
+	w.mutex.Lock()
+	events := w.entries()
+	w.mutex.Unlock()
+
+	return interpolate(events, t)
+}
+
+// GetVolumeRange gets the volume in 1/1000 gallon units that passed through
+// the meter between the specified times.  The range is clamped to the
+// retained history, so 0 is returned for a range entirely outside of it or
+// when from is after to.
+func (w *Watermeter) GetVolumeRange(from, to time.Time) uint64 {
+	if from.After(to) {
+		return 0
+	}
+
+	w.mutex.Lock()
+	events := w.entries()
+	w.mutex.Unlock()
+
+	return netVolume(interpolate(events, to), interpolate(events, from))
+}
+
+// netVolume returns the volume between the start and end totals, or 0 if the
+// total went down in between.
+func netVolume(end, start uint64) uint64 {
+	if end < start {
+		return 0
+	}
+	return end - start
+}
+
+// GetVolume gets the volume in 1/1000 gallon units that passed through the
+// meter over the specified duration.  It is measured from the same start as
+// GetFlow: the total interpolated between the events either side of the
+// window start, such as the boundary anchor and the event after it.
+//
+// When MaxEvents has evicted events that are still within Timeout, the
+// oldest of them is kept as an anchor so a window reaching past the retained
+// events still counts the evicted volume.  The window start is interpolated
+// between the anchor and the oldest retained event; volume from before the
+// anchor is not known.
+func (w *Watermeter) GetVolume(duration time.Duration) uint64 {
+	now := w.now()
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	start, _ := w.windowStart(now, now.Add(-duration))
+	return netVolume(w.total, start.total)
+}
+
+// IterateFlow calls fn with a FlowSample at each step after from through to,
+// stopping early if fn returns false.  Each sample has the total interpolated
+// at its time and the flow over the step ending at its time.  The events are
+// copied up front so the iteration is consistent even while updates run.
+func (w *Watermeter) IterateFlow(from, to time.Time, step time.Duration, fn func(FlowSample) bool) {
+	if 0 >= step {
+		return
+	}
+
+	w.mutex.Lock()
+	events := w.entries()
+	w.mutex.Unlock()
+
+	last := interpolate(events, from)
+	for t := from.Add(step); false == t.After(to); t = t.Add(step) {
+		total := interpolate(events, t)
+		if false == fn(FlowSample{Time: t, Total: total, Flow: rate(total-last, step)}) {
+			return
+		}
+		last = total
+	}
+}
+
+// maxSamplePreallocate is the most samples ResampleFlow and SlidingVolume
+// preallocate, so a tiny step over a long range grows the result as it is
+// filled rather than reserving it all up front.
+const maxSamplePreallocate = 4096
+
+// sampleCapacity returns the capacity to preallocate for the samples every
+// step over span.
+func sampleCapacity(span, step time.Duration) int {
+	if n := span / step; n < maxSamplePreallocate {
+		return int(n)
+	}
+	return maxSamplePreallocate
+}
+
+// ResampleFlow returns the flow at each interval boundary after start through
+// end, for sinks that want evenly spaced points regardless of when the pulses
+// arrived.  Each sample is computed as by IterateFlow from the totals
+// interpolated at adjacent boundaries; boundaries outside the retained
+// history are clamped to it and so read a flow of 0.
+func (w *Watermeter) ResampleFlow(start, end time.Time, interval time.Duration) []FlowSample {
+	if 0 >= interval || end.Before(start) {
+		return nil
+	}
+
+	rv := make([]FlowSample, 0, sampleCapacity(end.Sub(start), interval))
+	w.IterateFlow(start, end, interval, func(s FlowSample) bool {
+		rv = append(rv, s)
+		return true
+	})
+	return rv
+}
+
+// A VolumeSample is the volume over a trailing window.
+type VolumeSample struct {
+	// Time is the end of the window.
+	Time time.Time
+
+	// Volume is the volume in 1/1000 gallon units over the window.
+	Volume uint64
+}
+
+// SlidingVolume returns the volume over the trailing window at each step
+// after the start of the last span through now, the rolling usage curve a
+// chart plots.  The volumes are from the totals interpolated at each end of
+// the window.  A window reaching past the oldest retained event is clamped
+// to it, so the samples early in the span understate the volume when the
+// window is longer than the retained history.
+func (w *Watermeter) SlidingVolume(window, step time.Duration, span time.Duration) []VolumeSample {
+	if 0 >= step || 0 > window || 0 > span {
+		return nil
+	}
+	now := w.now()
+
+	w.mutex.Lock()
+	events := w.entries()
+	w.mutex.Unlock()
+
+	rv := make([]VolumeSample, 0, sampleCapacity(span, step))
+	for t := now.Add(-span).Add(step); false == t.After(now); t = t.Add(step) {
+		rv = append(rv, VolumeSample{
+			Time:   t,
+			Volume: netVolume(interpolate(events, t), interpolate(events, t.Add(-window))),
+		})
+	}
+	return rv
+}
+
+// An Event is a retained reading of a watermeter.
+type Event struct {
+	// Time is the time of the reading.
+	Time time.Time
+
+	// Total is the running total in 1/1000 gallon units.
+	Total uint64
+
+	// Heartbeat is true for an event added by HeartbeatInterval rather than
+	// an update.
+	Heartbeat bool
+}
+
+// DrainEvents atomically removes and returns the retained events, oldest
+// first, leaving a single event at the current time and total.  The running
+// total is unchanged but flow reads 0 until new updates arrive.
+func (w *Watermeter) DrainEvents() []Event {
+	now := w.now()
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	events := w.entries()
+	rv := make([]Event, len(events))
+	for i, e := range events {
+		rv[i] = Event{Time: e.time, Total: e.total, Heartbeat: e.heartbeat}
+	}
+
+	if newest := events[len(events)-1].time; now.Before(newest) {
+		now = newest
+	}
+	w.events.Init()
+	w.events.PushFront(&entry{time: now, total: w.total})
+	w.evicted = nil
+
+	return rv
+}
+
+// HistorySpan gets the time covered by the retained events, or 0 if there
+// are fewer than 2 of them.  A flow over a longer duration is understated.
+func (w *Watermeter) HistorySpan() time.Duration {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if 2 > w.events.Len() {
+		return 0
+	}
+	return w.events.Front().Value.(*entry).time.Sub(w.events.Back().Value.(*entry).time)
+}
+
+// OldestEventTime gets the time of the oldest retained event, the earliest
+// time a range query can be answered for.  It is the Init time until
+// updates arrive and then advances as old events are pruned.
+func (w *Watermeter) OldestEventTime() time.Time {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.events.Back().Value.(*entry).time
+}
+
+// FirstFlowTime gets the time of the first update since Init or Reset that
+// changed the total, when the sensor was first seen reporting water.  It is
+// kept apart from the history so pruning doesn't lose it.  The zero time is
+// returned until then.
+func (w *Watermeter) FirstFlowTime() time.Time {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.firstFlow
+}
+
+// GetLastUpdate gets the time of the last update, or of Init before any
+// update.  Heartbeats don't count, so with HeartbeatInterval set the history
+// may extend past it.
+func (w *Watermeter) GetLastUpdate() time.Time {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.lastUpdate
+}
+
+// PeriodConsumption gets the running totals in 1/1000 gallon units at the
+// start and end of a billing period and the volume consumed between them, so
+// the readings can be reconciled with a utility bill.  The totals are
+// interpolated between the retained events; a boundary outside the retained
+// history is clamped to it, so the consumption only covers the retained part
+// of the period.  Nothing is consumed if end is before start.
+func (w *Watermeter) PeriodConsumption(start, end time.Time) (startTotal, endTotal, consumed uint64) {
+	w.mutex.Lock()
+	events := w.entries()
+	w.mutex.Unlock()
+
+	startTotal = interpolate(events, start)
+	endTotal = interpolate(events, end)
+	if startTotal < endTotal {
+		consumed = endTotal - startTotal
+	}
+	return startTotal, endTotal, consumed
+}
+
+// A Gap is a stretch of the retained history without events.
+type Gap struct {
+	// Start and End are the times of the events either side of the gap.
+	Start time.Time
+	End   time.Time
+
+	// Duration is End less Start.
+	Duration time.Duration
+}
+
+// Gaps gets the gaps in the retained history, oldest first, where
+// consecutive events are more than maxExpectedInterval apart, such as a
+// sensor dropout the flow can't be trusted across.  It returns nil when the
+// history is dense or maxExpectedInterval isn't positive.
+func (w *Watermeter) Gaps(maxExpectedInterval time.Duration) []Gap {
+	if 0 >= maxExpectedInterval {
+		return nil
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	var rv []Gap
+	for item := w.events.Back(); nil != item && nil != item.Prev(); item = item.Prev() {
+		older, newer := item.Value.(*entry), item.Prev().Value.(*entry)
+		if d := newer.time.Sub(older.time); maxExpectedInterval < d {
+			rv = append(rv, Gap{Start: older.time, End: newer.time, Duration: d})
+		}
+	}
+	return rv
+}
@@ -0,0 +1,421 @@
+package watermeter
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func at(min, sec int) time.Time {
+	return time.Date(2016, time.December, 25, 1, min, sec, 0, time.UTC)
+}
+
+func TestGetVolumeRange(t *testing.T) {
+	assert := assert.New(t)
+
+	wm := Watermeter{Timeout: time.Hour}
+	setNow(&wm, 0)
+	wm.Init(1000)
+
+	setNow(&wm, 1)
+	wm.Update(500)
+	setNow(&wm, 2)
+	wm.Update(250)
+	setNow(&wm, 4)
+	wm.Update(1000)
+
+	assert.Equal(uint64(1000), wm.TotalAt(at(0, 0)))
+	assert.Equal(uint64(1250), wm.TotalAt(at(0, 30)))
+	assert.Equal(uint64(1750), wm.TotalAt(at(2, 0)))
+	assert.Equal(uint64(2250), wm.TotalAt(at(3, 0)))
+	assert.Equal(uint64(2750), wm.TotalAt(at(9, 0)))
+
+	// Interior range: half of the first interval through half of the last.
+	assert.Equal(uint64(1000), wm.GetVolumeRange(at(0, 30), at(3, 0)))
+	assert.Equal(uint64(1750), wm.GetVolumeRange(at(0, 0), at(4, 0)))
+
+	// Reversed and out of history ranges.
+	assert.Equal(uint64(0), wm.GetVolumeRange(at(3, 0), at(0, 30)))
+	assert.Equal(uint64(0), wm.GetVolumeRange(at(5, 0), at(9, 0)))
+	assert.Equal(uint64(0), wm.GetVolumeRange(time.Date(2016, time.December, 24, 1, 0, 0, 0, time.UTC), at(0, 0)))
+}
+
+func TestInterpolateSharedTimestamps(t *testing.T) {
+	assert := assert.New(t)
+
+	events := []entry{
+		{time: at(0, 0), total: 0},
+		{time: at(0, 0), total: 200},
+		{time: at(1, 0), total: 400},
+		{time: at(1, 0), total: 600},
+		{time: at(3, 0), total: 1000},
+	}
+
+	// A shared time gets the newest of its totals, and the interval after
+	// it starts from that total.
+	assert.Equal(uint64(200), interpolate(events, at(0, 0)))
+	assert.Equal(uint64(300), interpolate(events, at(0, 30)))
+	assert.Equal(uint64(600), interpolate(events, at(1, 0)))
+	assert.Equal(uint64(800), interpolate(events, at(2, 0)))
+	assert.Equal(uint64(1000), interpolate(events, at(9, 0)))
+
+	// Late updates are clamped onto the newest event's time.
+	wm := Watermeter{Timeout: time.Hour}
+	setNow(&wm, 0)
+	wm.Init(0)
+	wm.UpdateAt(at(1, 0), 250)
+	wm.UpdateAt(at(0, 30), 250)
+	assert.Equal(uint64(500), wm.TotalAt(at(1, 0)))
+	assert.Equal(uint64(125), wm.TotalAt(at(0, 30)))
+}
+
+func TestGetVolumeMaxEvents(t *testing.T) {
+	assert := assert.New(t)
+
+	wm := Watermeter{Timeout: time.Hour, MaxEvents: 3}
+	setNow(&wm, 0)
+	wm.Init(0)
+
+	for min := 1; min <= 6; min++ {
+		setNow(&wm, min)
+		wm.Update(1000)
+	}
+	assert.Equal(3, wm.events.Len())
+
+	// The window covers all of the history, including the evicted events.
+	assert.Equal(uint64(6000), wm.GetVolume(10*time.Minute))
+	assert.Equal(uint64(6000), wm.GetVolume(6*time.Minute))
+
+	// The window starts between the anchor and the oldest retained event.
+	assert.Equal(uint64(4500), wm.GetVolume(4*time.Minute+30*time.Second))
+
+	// The window is within the retained events.
+	assert.Equal(uint64(2000), wm.GetVolume(2*time.Minute))
+	assert.Equal(uint64(0), wm.GetVolume(0))
+
+	// Never less than the retained span.
+	for d := time.Duration(0); d < 10*time.Minute; d += 10 * time.Second {
+		if 2*time.Minute <= d {
+			assert.True(2000 <= wm.GetVolume(d))
+		}
+	}
+}
+
+func TestGetVolumeBoundaryAnchor(t *testing.T) {
+	assert := assert.New(t)
+
+	wm := Watermeter{Timeout: 9 * time.Minute}
+	setNow(&wm, 0)
+	wm.Init(0)
+
+	for min := 1; min <= 15; min += 2 {
+		setNow(&wm, min)
+		wm.Update(1000)
+	}
+
+	// The event at 5 is retained as the anchor for the edge at 6.
+	assert.Equal(at(5, 0), wm.OldestEventTime())
+	assert.Equal(6, wm.events.Len())
+	assert.Equal(uint64(4500), wm.GetVolume(9*time.Minute))
+	assert.Equal(0.5, wm.GetFlow(9*time.Minute))
+	flow, span, samples := wm.GetFlowWithSpan(9 * time.Minute)
+	assert.Equal(0.5, flow)
+	assert.Equal(9*time.Minute, span)
+	assert.Equal(5, samples)
+
+	// Shorter windows are interpolated between the events either side too.
+	assert.Equal(uint64(3500), wm.GetVolume(7*time.Minute))
+	assert.Equal(0.5, wm.GetFlow(7*time.Minute))
+
+	// Without the anchor the window snaps to the event at 7.
+	wm.events.Remove(wm.events.Back())
+	assert.Equal(uint64(4000), wm.GetVolume(9*time.Minute))
+	assert.Equal(4.0/9, wm.GetFlow(9*time.Minute))
+}
+
+func TestGetVolumeAnchorEdge(t *testing.T) {
+	assert := assert.New(t)
+
+	// The seed is the anchor once it is older than Timeout, but a shorter
+	// window is measured the same way either side of that edge.
+	for _, min := range []int{59, 61} {
+		wm := Watermeter{Timeout: time.Hour}
+		setNow(&wm, 0)
+		wm.Init(0)
+		setNow(&wm, min-10)
+		wm.Update(1000)
+		setNow(&wm, min)
+		wm.Update(1000)
+		assert.Equal(3, wm.events.Len())
+
+		want := 2000 - uint64(1000*(min-30)/(min-10))
+		assert.Equal(want, wm.GetVolume(30*time.Minute), "minute %d", min)
+		assert.InDelta(float64(want)/30000, wm.GetFlow(30*time.Minute), 1e-9, "minute %d", min)
+	}
+}
+
+func TestIterateFlow(t *testing.T) {
+	assert := assert.New(t)
+
+	wm := Watermeter{Timeout: time.Hour}
+	setNow(&wm, 0)
+	wm.Init(0)
+	setNow(&wm, 2)
+	wm.Update(2000)
+	setNow(&wm, 3)
+	wm.Update(500)
+
+	samples := []FlowSample{}
+	wm.IterateFlow(at(0, 0), at(4, 0), 30*time.Second, func(s FlowSample) bool {
+		samples = append(samples, s)
+		return true
+	})
+	assert.Equal(8, len(samples))
+	assert.Equal(FlowSample{Time: at(0, 30), Total: 500, Flow: 1.0}, samples[0])
+	assert.Equal(FlowSample{Time: at(2, 0), Total: 2000, Flow: 1.0}, samples[3])
+	assert.Equal(FlowSample{Time: at(2, 30), Total: 2250, Flow: 0.5}, samples[4])
+	assert.Equal(FlowSample{Time: at(4, 0), Total: 2500, Flow: 0.0}, samples[7])
+
+	// Stops when fn returns false.
+	count := 0
+	wm.IterateFlow(at(0, 0), at(4, 0), time.Minute, func(s FlowSample) bool {
+		count++
+		return 2 > count
+	})
+	assert.Equal(2, count)
+
+	wm.IterateFlow(at(0, 0), at(4, 0), 0, func(s FlowSample) bool {
+		assert.Fail("no samples for an empty step")
+		return true
+	})
+}
+
+func TestSlidingVolume(t *testing.T) {
+	assert := assert.New(t)
+
+	wm := Watermeter{Timeout: time.Hour}
+	setNow(&wm, 0)
+	wm.Init(0)
+	for min := 1; min <= 10; min++ {
+		setNow(&wm, min)
+		wm.Update(uint(1000 * min))
+	}
+
+	// The trailing two minutes at 7, 8, 9 and 10.
+	samples := wm.SlidingVolume(2*time.Minute, time.Minute, 4*time.Minute)
+	assert.Equal([]VolumeSample{
+		{Time: at(7, 0), Volume: 13000},
+		{Time: at(8, 0), Volume: 15000},
+		{Time: at(9, 0), Volume: 17000},
+		{Time: at(10, 0), Volume: 19000},
+	}, samples)
+
+	// Half steps are interpolated.
+	samples = wm.SlidingVolume(time.Minute, 30*time.Second, time.Minute)
+	assert.Equal([]VolumeSample{
+		{Time: at(9, 30), Volume: 9500},
+		{Time: at(10, 0), Volume: 10000},
+	}, samples)
+
+	// A window longer than the history is clamped to it.
+	samples = wm.SlidingVolume(10*time.Minute, time.Minute, 2*time.Minute)
+	assert.Equal([]VolumeSample{
+		{Time: at(9, 0), Volume: 45000},
+		{Time: at(10, 0), Volume: 55000},
+	}, samples)
+
+	assert.Nil(wm.SlidingVolume(time.Minute, 0, time.Minute))
+	assert.Equal(0, len(wm.SlidingVolume(time.Minute, time.Minute, 0)))
+}
+
+func TestDrainEvents(t *testing.T) {
+	assert := assert.New(t)
+
+	wm := Watermeter{Timeout: time.Hour}
+	setNow(&wm, 0)
+	wm.Init(500)
+	setNow(&wm, 1)
+	wm.Update(1000)
+	setNow(&wm, 2)
+	wm.Update(250)
+
+	setNow(&wm, 3)
+	assert.Equal([]Event{
+		{Time: at(0, 0), Total: 500},
+		{Time: at(1, 0), Total: 1500},
+		{Time: at(2, 0), Total: 1750},
+	}, wm.DrainEvents())
+
+	assert.Equal(uint64(1), wm.GetGallons())
+	assert.Equal(0.0, wm.GetFlow(5*time.Minute))
+	assert.Equal([]Event{{Time: at(3, 0), Total: 1750}}, wm.DrainEvents())
+
+	setNow(&wm, 4)
+	wm.Update(1000)
+	assert.Equal(1.0, wm.GetFlow(time.Minute))
+	assert.Equal(uint64(2), wm.GetGallons())
+}
+
+func TestHistorySpan(t *testing.T) {
+	assert := assert.New(t)
+
+	wm := Watermeter{Timeout: 3 * time.Minute}
+	setNow(&wm, 0)
+	wm.Init(0)
+	assert.Equal(time.Duration(0), wm.HistorySpan())
+
+	setNow(&wm, 1)
+	wm.Update(100)
+	assert.Equal(time.Minute, wm.HistorySpan())
+
+	setNow(&wm, 3)
+	wm.Update(100)
+	assert.Equal(3*time.Minute, wm.HistorySpan())
+
+	// Pruning drops the events older than the timeout but the anchor.
+	setNow(&wm, 5)
+	wm.Update(100)
+	assert.Equal(4*time.Minute, wm.HistorySpan())
+}
+
+func TestPeriodConsumption(t *testing.T) {
+	assert := assert.New(t)
+
+	wm := Watermeter{Timeout: time.Hour}
+	setNow(&wm, 0)
+	wm.Init(100000)
+	setNow(&wm, 10)
+	wm.Update(10000)
+	setNow(&wm, 20)
+	wm.Update(20000)
+
+	start, end, consumed := wm.PeriodConsumption(at(5, 0), at(15, 0))
+	assert.Equal(uint64(105000), start)
+	assert.Equal(uint64(120000), end)
+	assert.Equal(uint64(15000), consumed)
+
+	start, end, consumed = wm.PeriodConsumption(at(15, 0), at(5, 0))
+	assert.Equal(uint64(120000), start)
+	assert.Equal(uint64(105000), end)
+	assert.Equal(uint64(0), consumed)
+}
+
+func TestSampleCapacity(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(4, sampleCapacity(4*time.Minute, time.Minute))
+	assert.Equal(0, sampleCapacity(time.Second, time.Minute))
+
+	// A tiny step over a long range isn't reserved up front.
+	assert.Equal(maxSamplePreallocate, sampleCapacity(365*24*time.Hour, time.Nanosecond))
+
+	wm := Watermeter{Timeout: time.Hour}
+	setNow(&wm, 0)
+	wm.Init(0)
+	setNow(&wm, 1)
+	wm.Update(1000)
+	assert.Equal(60000, len(wm.ResampleFlow(at(0, 0), at(1, 0), time.Millisecond)))
+	assert.Equal(60000, len(wm.SlidingVolume(time.Second, time.Millisecond, time.Minute)))
+}
+
+func TestResampleFlow(t *testing.T) {
+	assert := assert.New(t)
+
+	wm := Watermeter{Timeout: time.Hour}
+	setNow(&wm, 0)
+	wm.Init(0)
+	for _, u := range []struct {
+		t time.Time
+		m uint
+	}{
+		{at(0, 45), 1000},
+		{at(2, 10), 3000},
+		{at(2, 30), 500},
+	} {
+		wm.now = func() time.Time { return u.t }
+		wm.Update(u.m)
+	}
+
+	samples := wm.ResampleFlow(at(0, 0), at(3, 0), time.Minute)
+	assert.Equal(3, len(samples))
+
+	// 1000 + 3000*15/85 and 1000 + 3000*75/85, then clamped to the newest.
+	totals := []uint64{1529, 3647, 4500}
+	flows := []float64{1.529, 2.118, 0.853}
+	for i, s := range samples {
+		assert.True(at(i+1, 0).Equal(s.Time))
+		assert.Equal(totals[i], s.Total)
+		assert.InDelta(flows[i], s.Flow, 1e-9)
+	}
+
+	// Before the history everything is clamped to the seed.
+	samples = wm.ResampleFlow(at(-1, 0), at(0, 0), 30*time.Second)
+	assert.Equal(2, len(samples))
+	for _, s := range samples {
+		assert.Equal(0.0, s.Flow)
+	}
+
+	assert.Nil(wm.ResampleFlow(at(3, 0), at(0, 0), time.Minute))
+	assert.Nil(wm.ResampleFlow(at(0, 0), at(3, 0), 0))
+}
+
+func TestOldestEventTime(t *testing.T) {
+	assert := assert.New(t)
+
+	wm := Watermeter{Timeout: 3 * time.Minute}
+	setNow(&wm, 0)
+	wm.Init(0)
+	assert.Equal(at(0, 0), wm.OldestEventTime())
+	assert.Equal(at(0, 0), wm.GetLastUpdate())
+
+	for min := 1; min <= 6; min++ {
+		setNow(&wm, min)
+		wm.Update(100)
+		assert.Equal(at(min, 0), wm.GetLastUpdate())
+	}
+
+	// Events before minute 3 were pruned.
+	assert.Equal(at(3, 0), wm.OldestEventTime())
+	setNow(&wm, 8)
+	wm.Update(100)
+	assert.Equal(at(5, 0), wm.OldestEventTime())
+}
+
+func TestFirstFlowTime(t *testing.T) {
+	assert := assert.New(t)
+
+	wm := Watermeter{Timeout: 3 * time.Minute}
+	setNow(&wm, 0)
+	wm.Init(0)
+	assert.True(wm.FirstFlowTime().IsZero())
+
+	setNow(&wm, 1)
+	wm.Update(0)
+	assert.True(wm.FirstFlowTime().IsZero())
+
+	for min := 2; min <= 10; min++ {
+		setNow(&wm, min)
+		wm.Update(100)
+		assert.Equal(at(2, 0), wm.FirstFlowTime())
+	}
+	assert.True(wm.OldestEventTime().After(at(2, 0)))
+
+	wm.Reset()
+	assert.True(wm.FirstFlowTime().IsZero())
+}
+
+func TestGaps(t *testing.T) {
+	assert := assert.New(t)
+
+	wm := Watermeter{Timeout: time.Hour}
+	setNow(&wm, 0)
+	wm.Init(0)
+	for _, min := range []int{1, 2, 3, 12, 13} {
+		setNow(&wm, min)
+		wm.Update(100)
+	}
+
+	assert.Equal([]Gap{{Start: at(3, 0), End: at(12, 0), Duration: 9 * time.Minute}}, wm.Gaps(2*time.Minute))
+	assert.Nil(wm.Gaps(10 * time.Minute))
+	assert.Nil(wm.Gaps(0))
+}
@@ -0,0 +1,57 @@
+package watermeter
+
+import (
+	"log/slog"
+	"time"
+)
+
+// minIdleCheckInterval is the shortest interval the meter checks if it is
+// idle at.
+const minIdleCheckInterval = time.Millisecond
+
+// watchIdle checks if the meter is idle every IdleCheckInterval until stop
+// is closed.
+func (w *Watermeter) watchIdle(stop <-chan struct{}) {
+	interval := w.IdleCheckInterval
+	if 0 >= interval {
+		interval = w.IdleTimeout / 4
+	}
+	if interval < minIdleCheckInterval {
+		interval = minIdleCheckInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			w.checkIdle()
+		}
+	}
+}
+
+// checkIdle calls Idle if the meter has just become idle.
+func (w *Watermeter) checkIdle() {
+	now := w.now()
+
+	w.mutex.Lock()
+	since := w.lastUpdate
+	total := w.total
+	idle := false
+	if false == w.idle && w.IdleTimeout <= now.Sub(since) {
+		w.idle = true
+		idle = w.raise(AlertIdle, now, since)
+	}
+	w.mutex.Unlock()
+
+	if idle && w.logs(slog.LevelWarn) {
+		w.log(slog.LevelWarn, "idle", total, slog.Time("since", since))
+	}
+
+	if fn := w.Idle; idle && nil != fn {
+		w.dispatch(func() { fn(since) })
+	}
+}
@@ -0,0 +1,111 @@
+package watermeter
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestIdle(t *testing.T) {
+	assert := assert.New(t)
+
+	// A long check interval keeps the ticker out of the way; checkIdle is
+	// driven directly with the injected clock.
+	var idle []time.Time
+	wm := Watermeter{
+		Timeout:       time.Hour,
+		IdleTimeout:   5 * time.Minute,
+		SyncCallbacks: true,
+		Idle:          func(since time.Time) { idle = append(idle, since) },
+	}
+	setNow(&wm, 0)
+	wm.Init(0)
+	defer wm.Close()
+
+	setNow(&wm, 1)
+	wm.Update(100)
+
+	// Not before IdleTimeout has passed since the last update.
+	setNow(&wm, 5)
+	wm.checkIdle()
+	assert.Empty(idle)
+
+	setNow(&wm, 6)
+	wm.checkIdle()
+	assert.Equal([]time.Time{at(1, 0)}, idle)
+
+	// Only once per idle period.
+	setNow(&wm, 7)
+	wm.checkIdle()
+	assert.Equal([]time.Time{at(1, 0)}, idle)
+
+	// An update starts a new one.
+	setNow(&wm, 8)
+	wm.Update(100)
+	setNow(&wm, 13)
+	wm.checkIdle()
+	assert.Equal([]time.Time{at(1, 0), at(8, 0)}, idle)
+}
+
+func TestIdleDefaultInterval(t *testing.T) {
+	assert := assert.New(t)
+
+	idle := make(chan time.Time, 1)
+	wm := Watermeter{
+		Timeout:     time.Minute,
+		IdleTimeout: 40 * time.Millisecond,
+		Idle:        func(since time.Time) { idle <- since },
+	}
+	wm.Init(0)
+
+	select {
+	case <-idle:
+	case <-time.After(time.Second):
+		assert.Fail("Idle was not called")
+	}
+
+	assert.Nil(wm.Close())
+	assert.Nil(wm.Close())
+}
+
+func TestIdleShortInterval(t *testing.T) {
+	assert := assert.New(t)
+
+	idle := make(chan time.Time, 1)
+	wm := Watermeter{
+		Timeout:     time.Minute,
+		IdleTimeout: 3 * time.Nanosecond,
+		Idle:        func(since time.Time) { idle <- since },
+	}
+	wm.Init(0)
+
+	// The check interval is clamped rather than panicking the ticker.
+	select {
+	case <-idle:
+	case <-time.After(time.Second):
+		assert.Fail("Idle was not called")
+	}
+	assert.Nil(wm.Close())
+}
+
+func TestIdleSyncClose(t *testing.T) {
+	assert := assert.New(t)
+
+	// A synchronous Idle callback runs on the meter's goroutine, so it
+	// hands Close to a new goroutine rather than waiting on itself.
+	closed := make(chan error, 1)
+	wm := Watermeter{
+		Timeout:       time.Minute,
+		IdleTimeout:   10 * time.Millisecond,
+		SyncCallbacks: true,
+	}
+	wm.Idle = func(since time.Time) { go func() { closed <- wm.Close() }() }
+	wm.Init(0)
+
+	select {
+	case err := <-closed:
+		assert.Nil(err)
+	case <-time.After(time.Second):
+		assert.Fail("Close from Idle did not return")
+	}
+}
@@ -0,0 +1,17 @@
+package watermeter
+
+import (
+	"context"
+	"log/slog"
+)
+
+// logs returns true if the Logger is set and logs at level.
+func (w *Watermeter) logs(level slog.Level) bool {
+	return nil != w.Logger && w.Logger.Enabled(context.Background(), level)
+}
+
+// log logs msg with the name and total of the meter followed by attrs.
+func (w *Watermeter) log(level slog.Level, msg string, total uint64, attrs ...slog.Attr) {
+	attrs = append([]slog.Attr{slog.String("name", w.Name), slog.Uint64("total", total)}, attrs...)
+	w.Logger.LogAttrs(context.Background(), level, msg, attrs...)
+}
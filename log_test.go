@@ -0,0 +1,97 @@
+package watermeter
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+)
+
+type record struct {
+	level slog.Level
+	msg   string
+	attrs map[string]string
+}
+
+type recorder struct {
+	mutex   sync.Mutex
+	level   slog.Level
+	records []record
+}
+
+func (r *recorder) Enabled(_ context.Context, level slog.Level) bool {
+	return r.level <= level
+}
+
+func (r *recorder) Handle(_ context.Context, rec slog.Record) error {
+	attrs := make(map[string]string)
+	rec.Attrs(func(a slog.Attr) bool {
+		attrs[a.Key] = a.Value.String()
+		return true
+	})
+
+	r.mutex.Lock()
+	r.records = append(r.records, record{level: rec.Level, msg: rec.Message, attrs: attrs})
+	r.mutex.Unlock()
+	return nil
+}
+
+func (r *recorder) WithAttrs([]slog.Attr) slog.Handler { return r }
+func (r *recorder) WithGroup(string) slog.Handler      { return r }
+
+func (r *recorder) messages() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	rv := []string{}
+	for _, rec := range r.records {
+		rv = append(rv, rec.level.String()+" "+rec.msg)
+	}
+	return rv
+}
+
+func TestLogger(t *testing.T) {
+	assert := assert.New(t)
+
+	r := &recorder{level: slog.LevelDebug}
+	wm := Watermeter{
+		Name:            "main",
+		Timeout:         time.Minute,
+		MaxSingleUpdate: 5000,
+		StuckTimeout:    time.Minute,
+		Logger:          slog.New(r),
+	}
+	setNow(&wm, 0)
+	wm.Init(0)
+
+	setNow(&wm, 1)
+	wm.Update(1000)
+	setNow(&wm, 3)
+	wm.Update(0)
+	wm.Update(9000)
+
+	assert.Equal([]string{
+		"DEBUG update",
+		"DEBUG usage",
+		"DEBUG update",
+		"DEBUG prune",
+		"WARN stuck",
+		"WARN rejected",
+	}, r.messages())
+
+	usage := r.records[1]
+	assert.Equal("main", usage.attrs["name"])
+	assert.Equal("1000", usage.attrs["total"])
+	assert.Equal("1", usage.attrs["gallons"])
+	assert.Equal("1", usage.attrs["flow"])
+	assert.Equal(ErrImplausibleUpdate.Error(), r.records[5].attrs["error"])
+
+	// Only warnings are logged at a higher level.
+	r.records = nil
+	r.level = slog.LevelWarn
+	wm.Update(10000)
+	wm.Update(1)
+	assert.Equal([]string{"WARN rejected"}, r.messages())
+}
@@ -0,0 +1,139 @@
+package watermeter
+
+import (
+	"container/list"
+	"errors"
+	"math"
+	"unsafe"
+)
+
+// maxPreallocate is the most events WithExpectedRate preallocates.
+const maxPreallocate = 1 << 20
+
+// entrySize is the approximate number of bytes each retained event costs:
+// the entry itself plus the list element holding it.  Allocator rounding
+// and the garbage collector's overhead are not counted.
+func entrySize() int {
+	return int(unsafe.Sizeof(entry{}) + unsafe.Sizeof(list.Element{}))
+}
+
+// WithMaxMemory caps the memory used by the retained events at roughly
+// bytes.  The cap is converted to an event count from the current per event
+// cost each time the meter prunes, and the lower of it and MaxEvents
+// applies.  The newest two events are always retained, so a budget smaller
+// than that is exceeded.
+func WithMaxMemory(bytes int) Option {
+	return func(w *Watermeter) error {
+		if 0 >= bytes {
+			return errors.New("watermeter: max memory must be positive")
+		}
+		w.maxMemory = bytes
+		return nil
+	}
+}
+
+// maxEvents returns the effective limit on the number of retained events, or
+// zero for no limit.  A limit is never below the 2 events always kept.
+func (w *Watermeter) maxEvents() int {
+	max := w.MaxEvents
+	if 0 < w.maxMemory {
+		n := w.maxMemory / entrySize()
+		if 0 == max || n < max {
+			max = n
+		}
+		if 2 > max {
+			max = 2
+		}
+	} else if 0 < max && 2 > max {
+		max = 2
+	}
+	return max
+}
+
+// WithExpectedRate preallocates the events a meter pulsing at
+// pulsesPerMinute retains over Timeout, capped by MaxEvents and
+// WithMaxMemory, when it is initialized.  Pruned events are reused rather
+// than left for the garbage collector, so a meter running at or below the
+// expected rate doesn't allocate events once warm.  The list element holding
+// each event is still allocated per update.
+func WithExpectedRate(pulsesPerMinute float64) Option {
+	return func(w *Watermeter) error {
+		if false == (0 < pulsesPerMinute) {
+			return errors.New("watermeter: expected rate must be positive")
+		}
+		w.expectedRate = pulsesPerMinute
+		return nil
+	}
+}
+
+// preallocate refills the free list with the events expected over Timeout.
+// The caller must hold the mutex.
+func (w *Watermeter) preallocate() {
+	w.free = nil
+	if 0 >= w.expectedRate {
+		return
+	}
+
+	n := maxPreallocate
+	if expected := math.Ceil(w.expectedRate*w.Timeout.Minutes()) + 2; expected < maxPreallocate {
+		n = int(expected)
+	}
+	// One more than the limit is held until the prune.
+	if max := w.maxEvents(); 0 < max && max+1 < n {
+		n = max + 1
+	}
+
+	slab := make([]entry, n)
+	w.free = make([]*entry, n)
+	for i := range slab {
+		w.free[i] = &slab[i]
+	}
+}
+
+// newEntry returns an entry from the free list, or a new one if it is empty.
+// The caller must hold the mutex.
+func (w *Watermeter) newEntry() *entry {
+	if n := len(w.free); 0 < n {
+		e := w.free[n-1]
+		w.free = w.free[:n-1]
+		return e
+	}
+	return new(entry)
+}
+
+// recycle returns an entry that is no longer referenced to the free list if
+// the meter preallocates.  The caller must hold the mutex.
+func (w *Watermeter) recycle(e *entry) {
+	if 0 < w.expectedRate {
+		*e = entry{}
+		w.free = append(w.free, e)
+	}
+}
+
+// countEviction counts the event just removed from the back of the history,
+// along with the volume between it and the new oldest event.  The caller
+// must hold the mutex.
+func (w *Watermeter) countEviction(e *entry) {
+	w.evictions++
+	w.evictedVolume += netVolume(w.events.Back().Value.(*entry).total, e.total)
+}
+
+// GetEvictionCount gets the number of events pruned from the history over
+// the life of the meter, either for Timeout or past the MaxEvents or
+// WithMaxMemory limit.  It is kept by Reset.
+func (w *Watermeter) GetEvictionCount() uint64 {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.evictions
+}
+
+// GetEvictionVolume gets the volume in 1/1000 gallon units that has aged out
+// of the history through pruning over the life of the meter, the volume no
+// longer covered by a range query.  It is kept by Reset.
+func (w *Watermeter) GetEvictionVolume() uint64 {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.evictedVolume
+}
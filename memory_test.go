@@ -0,0 +1,145 @@
+package watermeter
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestWithMaxMemory(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := time.Date(2016, time.December, 25, 1, 0, 0, 0, time.UTC)
+	wm, err := New(0,
+		WithTimeout(time.Hour),
+		WithClock(func() time.Time { return clock }),
+		WithMaxMemory(10*entrySize()+entrySize()/2),
+	)
+	assert.Nil(err)
+	assert.Equal(10, wm.maxEvents())
+
+	for i := 1; i <= 100; i++ {
+		clock = clock.Add(time.Second)
+		wm.Update(250)
+		assert.LessOrEqual(wm.events.Len(), 10)
+	}
+	assert.Equal(uint64(25000), wm.total)
+	assert.Equal(uint64(25), wm.GetGallons())
+
+	// MaxEvents still applies when it is lower.
+	wm.MaxEvents = 4
+	assert.Equal(4, wm.maxEvents())
+
+	_, err = New(0, WithMaxMemory(0))
+	assert.NotNil(err)
+}
+
+func TestWithMaxMemoryFloor(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := time.Date(2016, time.December, 25, 1, 0, 0, 0, time.UTC)
+	wm, err := New(0,
+		WithTimeout(time.Hour),
+		WithClock(func() time.Time { return clock }),
+		WithMaxMemory(1),
+	)
+	assert.Nil(err)
+	assert.Equal(2, wm.maxEvents())
+
+	// The newest two events are kept however small the budget.
+	for i := 1; i <= 5; i++ {
+		clock = clock.Add(time.Minute)
+		wm.Update(1000)
+		assert.Equal(2, wm.events.Len())
+		assert.Nil(wm.Validate())
+	}
+	assert.InDelta(1.0, wm.GetFlow(time.Minute), 1e-9)
+
+	// So is a MaxEvents of 1.
+	wm = &Watermeter{Timeout: time.Hour, MaxEvents: 1}
+	wm.Init(0)
+	for i := 1; i <= 5; i++ {
+		wm.Update(1000)
+		assert.Equal(2, wm.events.Len())
+	}
+	assert.Nil(wm.Validate())
+}
+
+func TestWithExpectedRate(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := time.Date(2016, time.December, 25, 1, 0, 0, 0, time.UTC)
+	wm, err := New(0,
+		WithTimeout(time.Minute),
+		WithClock(func() time.Time { return clock }),
+		WithExpectedRate(60),
+	)
+	assert.Nil(err)
+	assert.Equal(61, len(wm.free))
+
+	// Several Timeouts at the expected rate reuse the pruned events.
+	for i := 0; i < 300; i++ {
+		clock = clock.Add(time.Second)
+		wm.Update(100)
+	}
+	assert.Equal(uint64(30000), wm.total)
+	assert.Nil(wm.Validate())
+	assert.Equal(0.1*60, wm.GetFlow(time.Minute))
+	assert.Equal(62, wm.events.Len()+len(wm.free))
+
+	_, err = New(0, WithExpectedRate(0))
+	assert.NotNil(err)
+}
+
+func benchmarkWarmup(b *testing.B, opts ...Option) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		clock := time.Date(2016, time.December, 25, 1, 0, 0, 0, time.UTC)
+		wm, _ := New(0, append([]Option{
+			WithTimeout(time.Minute),
+			WithClock(func() time.Time { return clock }),
+		}, opts...)...)
+
+		// Warm up over two Timeouts at 600 pulses/minute.
+		for j := 0; j < 1200; j++ {
+			clock = clock.Add(100 * time.Millisecond)
+			wm.Update(10)
+		}
+	}
+}
+
+func BenchmarkWarmupDefault(b *testing.B) {
+	benchmarkWarmup(b)
+}
+
+func BenchmarkWarmupExpectedRate(b *testing.B) {
+	benchmarkWarmup(b, WithExpectedRate(600))
+}
+
+func TestEvictionCounters(t *testing.T) {
+	assert := assert.New(t)
+
+	wm := Watermeter{Timeout: 10 * time.Minute, MaxEvents: 3}
+	setNow(&wm, 0)
+	wm.Init(0)
+
+	for min := 1; min <= 6; min++ {
+		setNow(&wm, min)
+		wm.Update(1000)
+	}
+
+	// The events at 0 through 3 were evicted past the cap.
+	assert.Equal(uint64(4), wm.GetEvictionCount())
+	assert.Equal(uint64(4000), wm.GetEvictionVolume())
+
+	// The events at 4 and 5 age out, leaving 6 as the anchor.
+	setNow(&wm, 20)
+	wm.Update(1000)
+	assert.Equal(2, wm.events.Len())
+	assert.Equal(uint64(6), wm.GetEvictionCount())
+	assert.Equal(uint64(6000), wm.GetEvictionVolume())
+
+	wm.Reset()
+	assert.Equal(uint64(6), wm.GetEvictionCount())
+	assert.Equal(uint64(6000), wm.GetEvictionVolume())
+}
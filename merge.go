@@ -0,0 +1,102 @@
+package watermeter
+
+import (
+	"errors"
+)
+
+// ErrMergeConflict is returned when the histories of two watermeters can't be
+// merged into one continuous record.
+var ErrMergeConflict = errors.New("watermeter: merge conflict")
+
+// monotonic returns true if the oldest-first events never go back in time or
+// total.
+func monotonic(events []entry) bool {
+	for i := 1; i < len(events); i++ {
+		if events[i].time.Before(events[i-1].time) || events[i].total < events[i-1].total {
+			return false
+		}
+	}
+	return true
+}
+
+// Merge folds the history of other into this watermeter, such as when a
+// meter is replaced mid-period.  The histories must not overlap in time.
+// The totals of other are offset so they continue from, or lead into, the
+// totals of this watermeter and the combined events are ordered by time.
+// The volume between the two histories is not known and isn't counted.
+// Tagged totals and the volume of other are added, and events beyond
+// Timeout or the event limits are pruned.  ErrMergeConflict is returned and
+// nothing changes if the histories overlap or either isn't monotonic.
+func (w *Watermeter) Merge(other *Watermeter) error {
+	if w == other {
+		return ErrMergeConflict
+	}
+
+	now := w.now()
+
+	other.mutex.Lock()
+	theirs := other.entries()
+	tags := make(map[string]uint64, len(other.tags))
+	for tag, total := range other.tags {
+		tags[tag] = total
+	}
+	other.mutex.Unlock()
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	mine := w.entries()
+	if false == monotonic(mine) || false == monotonic(theirs) {
+		return ErrMergeConflict
+	}
+
+	first, last := theirs[0], theirs[len(theirs)-1]
+	var merged []entry
+	switch {
+	case last.time.Before(mine[0].time):
+		// Other leads into this meter's history.
+		if mine[0].total < last.total-first.total {
+			return ErrMergeConflict
+		}
+		offset := last.total - mine[0].total
+		for _, e := range theirs {
+			e.total -= offset
+			merged = append(merged, e)
+		}
+		merged = append(merged, mine...)
+	case mine[len(mine)-1].time.Before(first.time):
+		// Other continues this meter's history.
+		merged = append(merged, mine...)
+		for _, e := range theirs {
+			e.total = e.total - first.total + w.total
+			merged = append(merged, e)
+		}
+	default:
+		return ErrMergeConflict
+	}
+
+	w.events.Init()
+	for i := range merged {
+		w.events.PushFront(&merged[i])
+	}
+	newest := merged[len(merged)-1]
+	w.total = newest.total
+	w.lastGallon = newest
+	w.lastUpdate = newest.time
+	w.lifetime += last.total - first.total
+	w.evicted = nil
+
+	for tag, total := range tags {
+		if nil == w.tags {
+			w.tags = make(map[string]uint64)
+		}
+		w.tags[tag] += total
+	}
+
+	if now.Before(newest.time) {
+		now = newest.time
+	}
+	w.pruneEvents(now.Add(-w.Timeout))
+
+	return nil
+}
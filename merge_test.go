@@ -0,0 +1,130 @@
+package watermeter
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestMerge(t *testing.T) {
+	assert := assert.New(t)
+
+	old := Watermeter{Timeout: time.Hour}
+	setNow(&old, 0)
+	old.Init(250000)
+	setNow(&old, 1)
+	old.UpdateTagged(1000, "shower")
+	setNow(&old, 2)
+	old.Update(1000)
+
+	replacement := Watermeter{Timeout: time.Hour}
+	setNow(&replacement, 5)
+	replacement.Init(0)
+	setNow(&replacement, 6)
+	replacement.UpdateTagged(2000, "shower")
+	setNow(&replacement, 7)
+	replacement.Update(2000)
+
+	// The new meter continues the old meter's history.
+	assert.Nil(old.Merge(&replacement))
+	setNow(&old, 7)
+	assert.Equal([]Event{
+		{Time: at(0, 0), Total: 250000},
+		{Time: at(1, 0), Total: 251000},
+		{Time: at(2, 0), Total: 252000},
+		{Time: at(5, 0), Total: 252000},
+		{Time: at(6, 0), Total: 254000},
+		{Time: at(7, 0), Total: 256000},
+	}, old.DrainEvents())
+	assert.Equal(uint64(256), old.GetGallons())
+	assert.Equal(uint64(3000), old.GetTaggedTotal("shower"))
+
+	// Merging the old meter into the new one offsets the old totals.
+	old = Watermeter{Timeout: time.Hour}
+	setNow(&old, 0)
+	old.Init(250000)
+	setNow(&old, 2)
+	old.Update(2000)
+
+	replacement = Watermeter{Timeout: time.Hour}
+	setNow(&replacement, 5)
+	replacement.Init(3000)
+	setNow(&replacement, 7)
+	replacement.Update(2000)
+
+	assert.Nil(replacement.Merge(&old))
+	assert.Equal(uint64(4000), replacement.GetVolumeRange(at(0, 0), at(7, 0)))
+	assert.Equal(uint64(1000), replacement.TotalAt(at(0, 0)))
+	assert.Equal(uint64(5), replacement.GetGallons())
+	assert.Equal(1.0, replacement.GetFlow(2*time.Minute))
+}
+
+func TestMergeConflict(t *testing.T) {
+	assert := assert.New(t)
+
+	a := Watermeter{Timeout: time.Hour}
+	setNow(&a, 0)
+	a.Init(0)
+	setNow(&a, 4)
+	a.Update(1000)
+
+	b := Watermeter{Timeout: time.Hour}
+	setNow(&b, 2)
+	b.Init(5000)
+	setNow(&b, 6)
+	b.Update(1000)
+
+	// Overlapping histories.
+	assert.Equal(ErrMergeConflict, a.Merge(&b))
+	assert.Equal(ErrMergeConflict, a.Merge(&a))
+
+	// An earlier history with more volume than this meter's starting total.
+	c := Watermeter{Timeout: time.Hour}
+	setNow(&c, 10)
+	c.Init(500)
+	assert.Equal(ErrMergeConflict, c.Merge(&a))
+	assert.Equal(uint64(500), c.TotalAt(at(0, 0)))
+
+	// A non-monotonic history.
+	d := Watermeter{Timeout: time.Hour}
+	setNow(&d, 20)
+	d.Init(0)
+	d.events.PushFront(&entry{time: at(21, 0), total: 0})
+	d.events.Front().Next().Value.(*entry).total = 10
+	assert.Equal(ErrMergeConflict, a.Merge(&d))
+}
+
+func TestMergeKeepsEvents(t *testing.T) {
+	assert := assert.New(t)
+
+	old := Watermeter{Timeout: time.Hour, MaxEvents: 4}
+	setNow(&old, 0)
+	old.Init(0)
+	setNow(&old, 1)
+	old.Update(2000)
+	setNow(&old, 2)
+	old.Update(2000)
+
+	replacement := Watermeter{Timeout: time.Hour, HeartbeatInterval: time.Minute}
+	setNow(&replacement, 5)
+	replacement.Init(0)
+	setNow(&replacement, 6)
+	replacement.UpdateTagged(3000, "shower")
+	setNow(&replacement, 8)
+	replacement.heartbeat()
+
+	setNow(&old, 8)
+	assert.Nil(old.Merge(&replacement))
+
+	// The merged events keep their tags and heartbeats.
+	events := old.entries()
+	assert.Equal("shower", events[len(events)-2].tag)
+	assert.True(events[len(events)-1].heartbeat)
+
+	// The merged volume counts toward the lifetime total.
+	assert.Equal(uint64(7), old.GetLifetimeGallons())
+
+	// The combined history is pruned to MaxEvents.
+	assert.Equal(4, len(events))
+	assert.Nil(old.Validate())
+}
@@ -0,0 +1,171 @@
+package watermeter
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// An Option configures a Watermeter.
+type Option func(*Watermeter) error
+
+// WithName sets the Name of the watermeter.
+func WithName(name string) Option {
+	return func(w *Watermeter) error {
+		w.Name = name
+		return nil
+	}
+}
+
+// WithTimeout sets how long events are retained.
+func WithTimeout(timeout time.Duration) Option {
+	return func(w *Watermeter) error {
+		w.Timeout = timeout
+		return nil
+	}
+}
+
+// WithUsage sets the Usage callback.
+func WithUsage(fn func(gallons uint64, flow float64)) Option {
+	return func(w *Watermeter) error {
+		w.Usage = fn
+		return nil
+	}
+}
+
+// WithUsageEx sets the UsageEx callback.
+func WithUsageEx(fn func(UsageEvent)) Option {
+	return func(w *Watermeter) error {
+		w.UsageEx = fn
+		return nil
+	}
+}
+
+// WithChange sets the Change callback.
+func WithChange(fn func()) Option {
+	return func(w *Watermeter) error {
+		w.Change = fn
+		return nil
+	}
+}
+
+// WithOnError sets the OnError callback.
+func WithOnError(fn func(err error)) Option {
+	return func(w *Watermeter) error {
+		w.OnError = fn
+		return nil
+	}
+}
+
+// WithPool sets the Pool that runs the callbacks.
+func WithPool(p *Pool) Option {
+	return func(w *Watermeter) error {
+		w.Pool = p
+		return nil
+	}
+}
+
+// WithLogger sets the Logger.
+func WithLogger(logger *slog.Logger) Option {
+	return func(w *Watermeter) error {
+		w.Logger = logger
+		return nil
+	}
+}
+
+// WithCoalesceWindow sets the CoalesceWindow.
+func WithCoalesceWindow(window time.Duration) Option {
+	return func(w *Watermeter) error {
+		w.CoalesceWindow = window
+		return nil
+	}
+}
+
+// WithContext ties the lifetime of the watermeter to ctx: canceling ctx
+// closes the meter, stopping every goroutine it started as Close does.
+// After ctx is canceled updates are dropped without error.
+func WithContext(ctx context.Context) Option {
+	return func(w *Watermeter) error {
+		w.ctx = ctx
+		return nil
+	}
+}
+
+// WithMaxInitial sets the largest initial total New accepts.
+func WithMaxInitial(max uint64) Option {
+	return func(w *Watermeter) error {
+		w.MaxInitial = max
+		return nil
+	}
+}
+
+// WithUnitsPerGallon sets the UnitsPerGallon.
+func WithUnitsPerGallon(units uint64) Option {
+	return func(w *Watermeter) error {
+		w.UnitsPerGallon = units
+		return nil
+	}
+}
+
+// WithHeartbeat sets the HeartbeatInterval.
+func WithHeartbeat(interval time.Duration) Option {
+	return func(w *Watermeter) error {
+		w.HeartbeatInterval = interval
+		return nil
+	}
+}
+
+// WithFlowThresholds sets the LowFlow and HighFlow thresholds of FlowState.
+func WithFlowThresholds(low, high float64) Option {
+	return func(w *Watermeter) error {
+		w.LowFlow = low
+		w.HighFlow = high
+		return nil
+	}
+}
+
+// WithClock sets the function used to get the current time.
+func WithClock(now func() time.Time) Option {
+	return func(w *Watermeter) error {
+		w.now = now
+		return nil
+	}
+}
+
+// apply applies the options to the watermeter in order.
+func (w *Watermeter) apply(opts []Option) error {
+	for _, opt := range opts {
+		if err := opt(w); nil != err {
+			return err
+		}
+	}
+	return nil
+}
+
+// New creates a watermeter configured by the options and initialized to the
+// initial running total in 1/1000 gallon units.  The initial total is checked
+// as by InitE.
+func New(initial uint64, opts ...Option) (*Watermeter, error) {
+	w := new(Watermeter)
+	if err := w.apply(opts); nil != err {
+		return nil, err
+	}
+
+	return w.InitE(initial)
+}
+
+// Restore creates a watermeter from state encoded by MarshalCompact and
+// configured by the options, so callbacks that can't be persisted are wired
+// up in the same step.  The options are applied before the state is
+// restored.
+func Restore(data []byte, opts ...Option) (*Watermeter, error) {
+	w := new(Watermeter)
+	if err := w.apply(opts); nil != err {
+		return nil, err
+	}
+	if err := w.UnmarshalCompact(data); nil != err {
+		return nil, err
+	}
+
+	return w, nil
+}
@@ -0,0 +1,117 @@
+package watermeter
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func clockAt(min int) func() time.Time {
+	return func() time.Time {
+		return time.Date(2016, time.December, 25, 1, min, 0, 0, time.UTC)
+	}
+}
+
+func TestNew(t *testing.T) {
+	assert := assert.New(t)
+
+	wm, err := New(2000, WithName("main"), WithTimeout(time.Minute), WithClock(clockAt(0)))
+	assert.Nil(err)
+	assert.Equal("main", wm.Name)
+	assert.Equal(time.Minute, wm.Timeout)
+	assert.Equal(uint64(2), wm.GetGallons())
+
+	bad := errors.New("bad option")
+	wm, err = New(0, func(*Watermeter) error { return bad })
+	assert.Nil(wm)
+	assert.Equal(bad, err)
+
+	wm, err = New(5000, WithMaxInitial(1000))
+	assert.Nil(wm)
+	assert.Equal(ErrImplausibleInitial, err)
+}
+
+func TestRestore(t *testing.T) {
+	assert := assert.New(t)
+
+	old, err := New(4500, WithName("garden"), WithClock(clockAt(0)))
+	assert.Nil(err)
+	data := old.MarshalCompact()
+
+	usage := make(chan uint64, 1)
+	errs := make(chan error, 1)
+	wm, err := Restore(data,
+		WithTimeout(time.Hour),
+		WithClock(clockAt(5)),
+		WithPool(nil),
+		WithChange(nil),
+		WithOnError(func(err error) { errs <- err }),
+		WithUsage(func(gallons uint64, flow float64) { usage <- gallons }))
+	assert.Nil(err)
+	assert.Equal("garden", wm.Name)
+	assert.Equal(uint64(4), wm.GetGallons())
+
+	wm.Update(600)
+	select {
+	case gallons := <-usage:
+		assert.Equal(uint64(5), gallons)
+	case <-time.After(time.Second):
+		assert.Fail("Usage was not called")
+	}
+
+	wm.MaxSingleUpdate = 1
+	wm.Update(2)
+	select {
+	case err := <-errs:
+		assert.Equal(ErrImplausibleUpdate, err)
+	case <-time.After(time.Second):
+		assert.Fail("OnError was not called")
+	}
+
+	wm, err = Restore(data[:3])
+	assert.Nil(wm)
+	assert.Equal(ErrInvalidCompact, err)
+
+	bad := errors.New("bad option")
+	wm, err = Restore(data, func(*Watermeter) error { return bad })
+	assert.Nil(wm)
+	assert.Equal(bad, err)
+}
+
+func TestWithContext(t *testing.T) {
+	assert := assert.New(t)
+
+	before := runtime.NumGoroutine()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	wm, err := New(0,
+		WithContext(ctx),
+		WithClock(clockAt(0)),
+		WithAutoPersist(filepath.Join(t.TempDir(), "meter"), time.Hour),
+		func(w *Watermeter) error {
+			w.IdleTimeout = time.Hour
+			return nil
+		},
+	)
+	assert.Nil(err)
+	wm.StartFlowPoller(time.Hour, func(float64) {})
+	assert.Equal(before+3, runtime.NumGoroutine())
+
+	wm.Update(1500)
+	cancel()
+	// Polled by hand since Eventually would add a goroutine of its own.
+	deadline := time.Now().Add(time.Second)
+	for before != runtime.NumGoroutine() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(before, runtime.NumGoroutine())
+
+	wm.Update(1500)
+	assert.Equal(uint64(1), wm.GetGallons())
+	assert.False(wm.background(func(<-chan struct{}) {}))
+}
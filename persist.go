@@ -0,0 +1,66 @@
+package watermeter
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// WithAutoPersist saves the watermeter to path every interval and when it is
+// closed.  The file holds the MarshalCompact state and may be loaded with
+// Restore.  Write errors are reported to OnError.
+func WithAutoPersist(path string, interval time.Duration) Option {
+	return func(w *Watermeter) error {
+		w.persistPath = path
+		w.persistInterval = interval
+		return nil
+	}
+}
+
+// Save atomically writes the MarshalCompact state of the watermeter to path
+// by writing and syncing a temporary file in the same directory and renaming
+// it.
+func (w *Watermeter) Save(path string) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if nil != err {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err = f.Write(w.MarshalCompact()); nil != err {
+		f.Close()
+		return err
+	}
+	if err = f.Sync(); nil != err {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); nil != err {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// autoPersist saves the watermeter every persistInterval and once more when
+// stop is closed.
+func (w *Watermeter) autoPersist(stop <-chan struct{}) {
+	ticker := time.NewTicker(w.persistInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			w.persist()
+			return
+		case <-ticker.C:
+			w.persist()
+		}
+	}
+}
+
+// persist saves the watermeter to persistPath, reporting errors to OnError.
+func (w *Watermeter) persist() {
+	if err := w.Save(w.persistPath); nil != err {
+		w.reject(err)
+	}
+}
@@ -0,0 +1,71 @@
+package watermeter
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAutoPersist(t *testing.T) {
+	assert := assert.New(t)
+
+	path := filepath.Join(t.TempDir(), "meter")
+	wm, err := New(1000, WithName("main"), WithAutoPersist(path, 10*time.Millisecond))
+	assert.Nil(err)
+
+	wm.Update(2500)
+
+	// Written on the interval.
+	var data []byte
+	for i := 0; i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+		if data, err = os.ReadFile(path); nil == err {
+			break
+		}
+	}
+	assert.Nil(err)
+
+	restored, err := Restore(data)
+	assert.Nil(err)
+	assert.Equal("main", restored.Name)
+
+	// And written on Close.
+	wm.Update(500)
+	assert.Nil(wm.Close())
+	data, err = os.ReadFile(path)
+	assert.Nil(err)
+	restored, err = Restore(data)
+	assert.Nil(err)
+	assert.Equal(uint64(4), restored.GetGallons())
+
+	// No temporary files are left behind.
+	files, _ := filepath.Glob(filepath.Join(filepath.Dir(path), "*"))
+	assert.Equal([]string{path}, files)
+}
+
+func TestAutoPersistError(t *testing.T) {
+	assert := assert.New(t)
+
+	errs := make(chan error, 1)
+	path := filepath.Join(t.TempDir(), "missing", "meter")
+	wm, err := New(0,
+		WithOnError(func(err error) {
+			select {
+			case errs <- err:
+			default:
+			}
+		}),
+		WithAutoPersist(path, time.Millisecond))
+	assert.Nil(err)
+	defer wm.Close()
+
+	select {
+	case err := <-errs:
+		assert.True(errors.Is(err, os.ErrNotExist))
+	case <-time.After(time.Second):
+		assert.Fail("OnError was not called")
+	}
+}
@@ -0,0 +1,44 @@
+package watermeter
+
+import (
+	"sync"
+	"time"
+)
+
+// StartFlowPoller calls fn with GetFlow(interval) every interval until the
+// returned stop function or Close is called.  If fn is slower than the
+// interval the missed ticks are skipped rather than queued.  Stop waits for
+// the poller to exit and may be called more than once.  Fn runs on the
+// poller's goroutine, so it must not call stop or Close itself.
+func (w *Watermeter) StartFlowPoller(interval time.Duration, fn func(flow float64)) (stop func()) {
+	done := make(chan struct{})
+	finished := make(chan struct{})
+
+	poll := func(closed <-chan struct{}) {
+		defer close(finished)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-closed:
+				return
+			case <-done:
+				return
+			case <-ticker.C:
+				fn(w.GetFlow(interval))
+			}
+		}
+	}
+
+	if 0 >= interval || false == w.background(poll) {
+		close(finished)
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+		<-finished
+	}
+}
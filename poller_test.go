@@ -0,0 +1,102 @@
+package watermeter
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStartFlowPoller(t *testing.T) {
+	assert := assert.New(t)
+
+	wm := Watermeter{Timeout: time.Hour}
+	setNow(&wm, 0)
+	wm.Init(0)
+	wm.now = func() time.Time { return at(0, 0).Add(time.Millisecond) }
+	wm.Update(100)
+
+	flows := make(chan float64, 100)
+	stop := wm.StartFlowPoller(time.Millisecond, func(flow float64) { flows <- flow })
+
+	for i := 0; i < 3; i++ {
+		select {
+		case flow := <-flows:
+			// 0.1 gallons within the last millisecond.
+			assert.Equal(6000.0, flow)
+		case <-time.After(time.Second):
+			assert.Fail("the poller was not called")
+		}
+	}
+
+	stop()
+	stop()
+	n := len(flows)
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(n, len(flows))
+}
+
+func TestStartFlowPollerSlow(t *testing.T) {
+	assert := assert.New(t)
+
+	wm := Watermeter{Timeout: time.Hour}
+	wm.Init(0)
+
+	var calls int64
+	stop := wm.StartFlowPoller(time.Millisecond, func(flow float64) {
+		atomic.AddInt64(&calls, 1)
+		time.Sleep(20 * time.Millisecond)
+	})
+	time.Sleep(100 * time.Millisecond)
+	stop()
+
+	// Overlapping ticks are skipped, not queued.
+	n := atomic.LoadInt64(&calls)
+	assert.True(1 <= n && n <= 10, "calls: %d", n)
+}
+
+func TestStartFlowPollerClose(t *testing.T) {
+	assert := assert.New(t)
+
+	wm := Watermeter{Timeout: time.Hour}
+	wm.Init(0)
+
+	var calls int64
+	stop := wm.StartFlowPoller(time.Millisecond, func(flow float64) { atomic.AddInt64(&calls, 1) })
+	time.Sleep(10 * time.Millisecond)
+
+	assert.Nil(wm.Close())
+	n := atomic.LoadInt64(&calls)
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(n, atomic.LoadInt64(&calls))
+	stop()
+
+	// Nothing runs once the meter is closed or for an empty interval.
+	wm.StartFlowPoller(time.Millisecond, func(flow float64) { assert.Fail("closed poller called") })()
+	wm.Init(0)
+	wm.StartFlowPoller(0, func(flow float64) { assert.Fail("empty interval poller called") })()
+	wm.Close()
+}
+
+func TestStartFlowPollerCloseFromFn(t *testing.T) {
+	assert := assert.New(t)
+
+	wm := Watermeter{Timeout: time.Hour}
+	wm.Init(0)
+
+	// Close waits for the poller, so fn hands it to a new goroutine.
+	closed := make(chan error, 1)
+	var once sync.Once
+	stop := wm.StartFlowPoller(time.Millisecond, func(flow float64) {
+		once.Do(func() { go func() { closed <- wm.Close() }() })
+	})
+
+	select {
+	case err := <-closed:
+		assert.Nil(err)
+	case <-time.After(time.Second):
+		assert.Fail("Close from the poller did not return")
+	}
+	stop()
+}
@@ -0,0 +1,85 @@
+package watermeter
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// A Pool is a bounded set of goroutines that run watermeter callbacks.  A
+// single Pool may be shared by any number of watermeters so the number of
+// callback goroutines stays fixed no matter how many meters are updating.
+//
+// Callbacks are queued without blocking.  When the queue is full the
+// callback is dropped and counted rather than stalling the update.
+type Pool struct {
+	queue   chan func()
+	dropped uint64
+	closed  bool
+	mutex   sync.RWMutex
+	wg      sync.WaitGroup
+}
+
+// NewPool creates a Pool of the specified number of workers sharing a queue
+// that holds up to size pending callbacks.
+func NewPool(workers, size int) *Pool {
+	if 1 > workers {
+		workers = 1
+	}
+	if 0 > size {
+		size = 0
+	}
+
+	p := &Pool{queue: make(chan func(), size)}
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+
+	return p
+}
+
+func (p *Pool) work() {
+	defer p.wg.Done()
+	for fn := range p.queue {
+		fn()
+	}
+}
+
+// Submit queues fn to be run by a worker.  It returns false and counts the
+// callback as dropped if the queue is full or the pool is closed.
+func (p *Pool) Submit(fn func()) bool {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	if false == p.closed {
+		select {
+		case p.queue <- fn:
+			return true
+		default:
+		}
+	}
+	atomic.AddUint64(&p.dropped, 1)
+	return false
+}
+
+// QueueDepth returns the number of callbacks waiting for a worker.
+func (p *Pool) QueueDepth() int {
+	return len(p.queue)
+}
+
+// Dropped returns the number of callbacks dropped because the queue was full.
+func (p *Pool) Dropped() uint64 {
+	return atomic.LoadUint64(&p.dropped)
+}
+
+// Close stops accepting callbacks and waits for the queued callbacks to run.
+func (p *Pool) Close() {
+	p.mutex.Lock()
+	if false == p.closed {
+		p.closed = true
+		close(p.queue)
+	}
+	p.mutex.Unlock()
+
+	p.wg.Wait()
+}
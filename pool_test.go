@@ -0,0 +1,65 @@
+package watermeter
+
+import (
+	"github.com/stretchr/testify/assert"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPoolBounded(t *testing.T) {
+	assert := assert.New(t)
+
+	pool := NewPool(4, 16)
+	baseline := runtime.NumGoroutine()
+
+	var calls, usage int64
+	meters := make([]*Watermeter, 8)
+	for i := range meters {
+		meters[i] = &Watermeter{
+			Timeout: time.Minute,
+			Pool:    pool,
+			Change: func() {
+				atomic.AddInt64(&calls, 1)
+				time.Sleep(time.Millisecond)
+			},
+			Usage: func(gallons uint64, flow float64) {
+				atomic.AddInt64(&usage, 1)
+			},
+		}
+		meters[i].Init(0)
+	}
+
+	var wg sync.WaitGroup
+	var peak int64
+	for _, m := range meters {
+		wg.Add(1)
+		go func(m *Watermeter) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				m.Update(100)
+				n := int64(runtime.NumGoroutine())
+				for {
+					old := atomic.LoadInt64(&peak)
+					if n <= old || atomic.CompareAndSwapInt64(&peak, old, n) {
+						break
+					}
+				}
+			}
+		}(m)
+	}
+	wg.Wait()
+
+	// Only the updating goroutines and the workers may exist.
+	assert.True(atomic.LoadInt64(&peak) <= int64(baseline+len(meters)+1))
+	assert.True(pool.QueueDepth() <= 16)
+	assert.True(0 < pool.Dropped())
+
+	pool.Close()
+	assert.Equal(0, pool.QueueDepth())
+	assert.Equal(int64(8*500+8*50), atomic.LoadInt64(&calls)+atomic.LoadInt64(&usage)+int64(pool.Dropped()))
+
+	assert.False(pool.Submit(func() {}))
+}
@@ -0,0 +1,127 @@
+package watermeter
+
+import (
+	"time"
+)
+
+// retainedDays is the number of daily buckets kept.
+const retainedDays = 90
+
+// hourlyProfile accumulates usage by hour of day and by calendar day.
+type hourlyProfile struct {
+	volume  [24]uint64
+	days    [24]int
+	last    [24]int
+	current [24]uint64
+	daily   []dayBucket
+}
+
+// minProfileDays is the fewest earlier days an hour needs before
+// DeviationFromProfile compares against it.
+const minProfileDays = 3
+
+// dayBucket is the usage during one calendar day.
+type dayBucket struct {
+	day    time.Time
+	volume uint64
+}
+
+// addDay adds the volume to the bucket for day, a local midnight, starting
+// a new bucket and an empty one for each skipped day as needed.
+func (p *hourlyProfile) addDay(day time.Time, mGallons uint64) {
+	if n := len(p.daily); 0 == n || p.daily[n-1].day.Before(day) {
+		if 0 < n {
+			next := p.daily[n-1].day.AddDate(0, 0, 1)
+			for ; next.Before(day); next = next.AddDate(0, 0, 1) {
+				p.daily = append(p.daily, dayBucket{day: next})
+			}
+		}
+		p.daily = append(p.daily, dayBucket{day: day})
+		if extra := len(p.daily) - retainedDays; 0 < extra {
+			p.daily = append(p.daily[:0], p.daily[extra:]...)
+		}
+	}
+	p.daily[len(p.daily)-1].volume += mGallons
+}
+
+// location returns the configured Location or the local time zone.
+func (w *Watermeter) location() *time.Location {
+	if nil == w.Location {
+		return time.Local
+	}
+	return w.Location
+}
+
+// dayKey returns a key identifying the calendar day of t.
+func dayKey(t time.Time) int {
+	y, m, d := t.Date()
+	return y*10000 + int(m)*100 + d
+}
+
+// addProfile adds the volume to the hour of day of t.  The caller must hold
+// the mutex.
+func (w *Watermeter) addProfile(t time.Time, mGallons uint64) {
+	if nil == w.profile {
+		w.profile = new(hourlyProfile)
+	}
+
+	t = t.In(w.location())
+	h, key := t.Hour(), dayKey(t)
+	if key != w.profile.last[h] {
+		w.profile.last[h] = key
+		w.profile.days[h]++
+		w.profile.current[h] = 0
+	}
+	w.profile.volume[h] += mGallons
+	w.profile.current[h] += mGallons
+	w.profile.addDay(time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location()), mGallons)
+}
+
+// GetHourlyProfile gets the average gallons used during each hour of the day
+// in the configured Location over the lifetime of the meter.  Each hour is
+// averaged over the days an update arrived during it, so an hour repeated by
+// a daylight saving time change is only counted once for that day.
+func (w *Watermeter) GetHourlyProfile() [24]float64 {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	var rv [24]float64
+	if nil == w.profile {
+		return rv
+	}
+	for h := range rv {
+		if 0 < w.profile.days[h] {
+			rv[h] = float64(w.profile.volume[h]) / 1000 / float64(w.profile.days[h])
+		}
+	}
+	return rv
+}
+
+// DeviationFromProfile gets how far the consumption so far during the
+// current hour is from the average of the same hour on earlier days, as a
+// percentage of that average.  A positive value means more than usual.  ok
+// is false until the hour has been used on minProfileDays earlier days.
+func (w *Watermeter) DeviationFromProfile() (percent float64, ok bool) {
+	now := w.now().In(w.location())
+	h, key := now.Hour(), dayKey(now)
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if nil == w.profile {
+		return 0, false
+	}
+
+	days, volume, current := w.profile.days[h], w.profile.volume[h], uint64(0)
+	if key == w.profile.last[h] {
+		current = w.profile.current[h]
+		days--
+		volume -= current
+	}
+	if days < minProfileDays || 0 == volume {
+		return 0, false
+	}
+
+	average := float64(volume) / float64(days)
+	return (float64(current) - average) / average * 100, true
+}
@@ -0,0 +1,102 @@
+package watermeter
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestGetHourlyProfile(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := time.Date(2016, time.December, 25, 0, 0, 0, 0, time.UTC)
+	wm := Watermeter{
+		Timeout:  time.Hour,
+		Location: time.UTC,
+		now:      func() time.Time { return clock },
+	}
+	wm.Init(0)
+
+	for day := 0; day < 3; day++ {
+		// 7am showers every day, 2 gallons 10 minutes apart.
+		for _, min := range []int{0, 10} {
+			clock = time.Date(2016, time.December, 25+day, 7, min, 0, 0, time.UTC)
+			wm.Update(1000)
+		}
+		// Evening irrigation on the first day only.
+		if 0 == day {
+			clock = time.Date(2016, time.December, 25, 19, 30, 0, 0, time.UTC)
+			wm.Update(30000)
+		}
+	}
+
+	profile := wm.GetHourlyProfile()
+	assert.Equal(2.0, profile[7])
+	assert.Equal(30.0, profile[19])
+	assert.Equal(0.0, profile[12])
+}
+
+func TestGetHourlyProfileDST(t *testing.T) {
+	assert := assert.New(t)
+
+	loc, err := time.LoadLocation("America/Denver")
+	if nil != err {
+		t.Skip("time zone database unavailable")
+	}
+
+	// 1am happens twice on 2016-11-06 in Denver.
+	clock := time.Date(2016, time.November, 6, 7, 15, 0, 0, time.UTC)
+	wm := Watermeter{
+		Timeout:  time.Hour,
+		Location: loc,
+		now:      func() time.Time { return clock },
+	}
+	wm.Init(0)
+
+	wm.Update(1000)
+	clock = clock.Add(time.Hour)
+	assert.Equal(1, clock.In(loc).Hour())
+	wm.Update(1000)
+
+	assert.Equal(2.0, wm.GetHourlyProfile()[1])
+}
+
+func TestDeviationFromProfile(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := time.Date(2016, time.December, 25, 6, 0, 0, 0, time.UTC)
+	wm := Watermeter{
+		Timeout:  time.Hour,
+		Location: time.UTC,
+		now:      func() time.Time { return clock },
+	}
+	wm.Init(0)
+
+	// 10 gallons during the 7am hour for three days.
+	for day := 0; day < 3; day++ {
+		clock = time.Date(2016, time.December, 25+day, 7, 15, 0, 0, time.UTC)
+		_, ok := wm.DeviationFromProfile()
+		assert.False(ok)
+		wm.Update(10000)
+	}
+
+	// Nothing yet this morning.
+	clock = time.Date(2016, time.December, 28, 7, 5, 0, 0, time.UTC)
+	percent, ok := wm.DeviationFromProfile()
+	assert.True(ok)
+	assert.Equal(-100.0, percent)
+
+	// A running toilet pushes it well past usual.
+	for min := 10; min <= 40; min += 10 {
+		clock = time.Date(2016, time.December, 28, 7, min, 0, 0, time.UTC)
+		wm.Update(10000)
+	}
+	percent, ok = wm.DeviationFromProfile()
+	assert.True(ok)
+	assert.Equal(300.0, percent)
+
+	// No history for this hour.
+	clock = time.Date(2016, time.December, 28, 9, 0, 0, 0, time.UTC)
+	_, ok = wm.DeviationFromProfile()
+	assert.False(ok)
+}
@@ -0,0 +1,133 @@
+package watermeter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrMalformedPulse is reported when a line written to a PulseWriter can't be
+// parsed.
+var ErrMalformedPulse = errors.New("watermeter: malformed pulse line")
+
+// ErrUncalibrated is reported when UpdatePulses is called without
+// UnitsPerGallon.
+var ErrUncalibrated = errors.New("watermeter: UnitsPerGallon is not set")
+
+// UpdatePulses updates the watermeter with count raw sensor pulses, each
+// 1/UnitsPerGallon of a gallon.  The pulses are counted since Init or Reset
+// and the total follows the count, so the fractions of a 1/1000 gallon unit
+// don't drift.  Pulses are rejected with ErrUncalibrated if UnitsPerGallon
+// isn't set.
+func (w *Watermeter) UpdatePulses(count uint) {
+	w.ensureInit()
+
+	w.mutex.Lock()
+	units := w.UnitsPerGallon
+	before := w.pulses
+	if 0 < units {
+		w.pulses += uint64(count)
+	}
+	after := w.pulses
+	w.mutex.Unlock()
+
+	if 0 == units {
+		w.reject(ErrUncalibrated)
+		return
+	}
+	w.update(w.now(), int64(after*1000/units-before*1000/units), "")
+}
+
+// GetPulses gets the number of pulses counted by UpdatePulses since Init or
+// Reset.
+func (w *Watermeter) GetPulses() uint64 {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.pulses
+}
+
+// Consume updates the watermeter with each pulse of 1/1000 gallons received
+// from pulses until the channel is closed or ctx is canceled, for sensor
+// drivers that deliver pulses on a channel.  It blocks, so run it in a
+// goroutine of its own.  Pulses are applied as by Update, with rejections
+// reported to OnError.
+func (w *Watermeter) Consume(ctx context.Context, pulses <-chan uint) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case mGallons, ok := <-pulses:
+			if false == ok {
+				return
+			}
+			w.Update(mGallons)
+		}
+	}
+}
+
+type pulseWriter struct {
+	w       *Watermeter
+	partial []byte
+}
+
+// PulseWriter returns a writer that updates the watermeter for each newline
+// delimited record written to it, so a serial stream can be copied straight
+// into the meter.  A record is either a count of 1/1000 gallons or an RFC 3339
+// timestamp and a count separated by a comma.  Records may be split across
+// writes.  Malformed records are reported to OnError and skipped.
+func (w *Watermeter) PulseWriter() io.Writer {
+	return &pulseWriter{w: w}
+}
+
+func (p *pulseWriter) Write(data []byte) (int, error) {
+	p.partial = append(p.partial, data...)
+	for {
+		i := bytes.IndexByte(p.partial, '\n')
+		if 0 > i {
+			break
+		}
+		p.record(string(p.partial[:i]))
+		p.partial = p.partial[i+1:]
+	}
+
+	// Don't keep a large array alive for a short partial line.
+	p.partial = append([]byte(nil), p.partial...)
+
+	return len(data), nil
+}
+
+// record applies a single record.
+func (p *pulseWriter) record(line string) {
+	line = strings.TrimSpace(line)
+	if "" == line {
+		return
+	}
+
+	var stamp string
+	if i := strings.IndexByte(line, ','); 0 <= i {
+		stamp, line = strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+	}
+
+	count, err := strconv.ParseUint(line, 10, 0)
+	if nil != err {
+		p.w.reject(ErrMalformedPulse)
+		return
+	}
+
+	if "" == stamp {
+		p.w.Update(uint(count))
+		return
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, stamp)
+	if nil != err {
+		p.w.reject(ErrMalformedPulse)
+		return
+	}
+	p.w.UpdateAt(t, uint(count))
+}
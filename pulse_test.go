@@ -0,0 +1,129 @@
+package watermeter
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPulseWriter(t *testing.T) {
+	assert := assert.New(t)
+
+	errs := make(chan error, 4)
+	wm := Watermeter{
+		Timeout: time.Hour,
+		OnError: func(err error) { errs <- err },
+	}
+	setNow(&wm, 0)
+	wm.Init(0)
+	setNow(&wm, 10)
+
+	pw := wm.PulseWriter()
+	for _, chunk := range []string{
+		"250\n",
+		"2016-12-25T01:05:00Z,5",
+		"00\n\n",
+		"bogus\r\n1000\r\n",
+		"2016-12-25T01:1",
+		"2:00Z,1000\nnot a time,5\n",
+		"unterminated 8",
+	} {
+		n, err := pw.Write([]byte(chunk))
+		assert.Nil(err)
+		assert.Equal(len(chunk), n)
+	}
+
+	assert.Equal(uint64(2750), wm.TotalAt(at(12, 0)))
+	assert.Equal(uint64(1750), wm.TotalAt(at(10, 0)))
+	assert.Equal(uint64(0), wm.TotalAt(at(0, 0)))
+
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errs:
+			assert.Equal(ErrMalformedPulse, err)
+		case <-time.After(time.Second):
+			assert.Fail("OnError was not called")
+		}
+	}
+
+	// io.Copy works straight from a stream.
+	_, err := io.Copy(pw, strings.NewReader("\n100\n"))
+	assert.Nil(err)
+	select {
+	case err := <-errs:
+		assert.Equal(ErrMalformedPulse, err)
+	case <-time.After(time.Second):
+		assert.Fail("OnError was not called")
+	}
+	assert.Equal(uint64(2850), wm.TotalAt(at(12, 0)))
+}
+
+func TestUpdatePulses(t *testing.T) {
+	assert := assert.New(t)
+
+	errs := make(chan error, 1)
+	wm := Watermeter{Timeout: time.Hour, OnError: func(err error) { errs <- err }}
+	setNow(&wm, 0)
+	wm.Init(0)
+
+	wm.UpdatePulses(1)
+	assert.Equal(ErrUncalibrated, <-errs)
+	assert.Equal(uint64(0), wm.GetPulses())
+
+	// A third of a 1/1000 gallon unit per pulse doesn't drift.
+	wm.UnitsPerGallon = 3000
+	for i := 0; i < 10; i++ {
+		wm.UpdatePulses(1)
+	}
+	assert.Equal(uint64(10), wm.GetPulses())
+	assert.Equal(uint64(3), wm.total)
+	wm.UpdatePulses(2)
+	assert.Equal(uint64(4), wm.total)
+}
+
+func TestConsume(t *testing.T) {
+	assert := assert.New(t)
+
+	errs := make(chan error, 1)
+	wm := Watermeter{Timeout: time.Hour, MaxSingleUpdate: 1000, OnError: func(err error) { errs <- err }}
+	setNow(&wm, 0)
+	wm.Init(0)
+
+	pulses := make(chan uint, 4)
+	pulses <- 250
+	pulses <- 5000
+	pulses <- 500
+	close(pulses)
+	wm.Consume(context.Background(), pulses)
+
+	assert.Equal(uint64(750), wm.total)
+	assert.Equal(ErrImplausibleUpdate, <-errs)
+}
+
+func TestConsumeCancel(t *testing.T) {
+	assert := assert.New(t)
+
+	wm := Watermeter{Timeout: time.Hour}
+	setNow(&wm, 0)
+	wm.Init(0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	pulses := make(chan uint)
+	done := make(chan struct{})
+	go func() {
+		wm.Consume(ctx, pulses)
+		close(done)
+	}()
+
+	pulses <- 100
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		assert.Fail("Consume did not stop")
+	}
+	assert.Equal(uint64(100), wm.total)
+}
@@ -0,0 +1,104 @@
+package watermeter
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrNotReconfigurable is returned by Reconfigure when an option sets
+// something that can only be set before Init.
+var ErrNotReconfigurable = errors.New("watermeter: option can't be changed after Init")
+
+// ErrInvalidConfig is returned by Reconfigure when the options leave the
+// configuration inconsistent.
+var ErrInvalidConfig = errors.New("watermeter: invalid configuration")
+
+// copyReconfigurable copies the settings Reconfigure may change from src to
+// dst.
+func copyReconfigurable(dst, src *Watermeter) {
+	dst.Timeout = src.Timeout
+	dst.MaxEvents = src.MaxEvents
+	dst.maxMemory = src.maxMemory
+	dst.CoalesceWindow = src.CoalesceWindow
+	dst.AlertCooldown = src.AlertCooldown
+	dst.StatsCacheTTL = src.StatsCacheTTL
+	dst.Usage = src.Usage
+	dst.UsageEx = src.UsageEx
+	dst.UsageFlowWindow = src.UsageFlowWindow
+	dst.UsageIncrement = src.UsageIncrement
+	dst.SuppressSeedFlow = src.SuppressSeedFlow
+	dst.Change = src.Change
+	dst.StuckTimeout = src.StuckTimeout
+	dst.Stuck = src.Stuck
+	dst.UnitsPerGallon = src.UnitsPerGallon
+	dst.LowFlow = src.LowFlow
+	dst.HighFlow = src.HighFlow
+	dst.FlowStateWindow = src.FlowStateWindow
+}
+
+// onlyReconfigurable returns true if nothing outside the settings
+// copyReconfigurable copies has been set on w.
+func (w *Watermeter) onlyReconfigurable() bool {
+	return "" == w.Name && 0 == w.MaxInitial && 0 == w.MaxSingleUpdate &&
+		nil == w.OnError && nil == w.Logger && Gallons == w.Unit &&
+		nil == w.Location && 0 == w.MaxReportedFlow && false == w.Bidirectional &&
+		nil == w.Pool && nil == w.Scheduler && false == w.Lite &&
+		false == w.SyncCallbacks && 0 == w.CallbackTimeout &&
+		0 == w.IdleTimeout && 0 == w.IdleCheckInterval && nil == w.Idle &&
+		0 == w.HeartbeatInterval && 0 == w.expectedRate && nil == w.now &&
+		nil == w.ctx && "" == w.persistPath && 0 == w.persistInterval
+}
+
+// validConfig returns true if the reconfigurable settings are consistent.
+func (w *Watermeter) validConfig() bool {
+	return 0 <= w.MaxEvents && 0 <= w.CoalesceWindow && w.CoalesceWindow < w.Timeout &&
+		0 <= w.AlertCooldown && 0 <= w.StatsCacheTTL && 0 <= w.StuckTimeout &&
+		0 <= w.UsageFlowWindow
+}
+
+// Reconfigure applies the options to the watermeter while it is in use as a
+// single change: either all of them take effect or, if an option fails or
+// the result is invalid, none do.  A Timeout that isn't positive is set to
+// DefaultTimeout as by Init.  The result must have a non-negative MaxEvents,
+// StuckTimeout, AlertCooldown, StatsCacheTTL and UsageFlowWindow, and a
+// CoalesceWindow shorter than Timeout, or ErrInvalidConfig is returned.
+//
+// Only Timeout, MaxEvents, WithMaxMemory, CoalesceWindow, AlertCooldown,
+// StatsCacheTTL, Usage, UsageEx, UsageFlowWindow, UsageIncrement,
+// SuppressSeedFlow, Change, StuckTimeout, Stuck, UnitsPerGallon, LowFlow,
+// HighFlow and FlowStateWindow can be reconfigured; ErrNotReconfigurable is
+// returned for an option that sets anything else.  The retained events are
+// pruned against the new Timeout and event limits before it returns.
+func (w *Watermeter) Reconfigure(opts ...Option) error {
+	var now time.Time
+	if nil != w.now {
+		now = w.now()
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	scratch := new(Watermeter)
+	copyReconfigurable(scratch, w)
+	if err := scratch.apply(opts); nil != err {
+		return err
+	}
+	if false == scratch.onlyReconfigurable() {
+		return ErrNotReconfigurable
+	}
+	if 0 >= scratch.Timeout {
+		scratch.Timeout = DefaultTimeout
+	}
+	if false == scratch.validConfig() {
+		return ErrInvalidConfig
+	}
+
+	copyReconfigurable(w, scratch)
+	if 0 < w.events.Len() {
+		if newest := w.events.Front().Value.(*entry); now.Before(newest.time) {
+			now = newest.time
+		}
+		w.pruneEvents(now.Add(-w.Timeout))
+	}
+	return nil
+}
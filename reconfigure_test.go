@@ -0,0 +1,183 @@
+package watermeter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"log/slog"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReconfigure(t *testing.T) {
+	assert := assert.New(t)
+
+	wm, err := New(0, WithName("main"), WithTimeout(time.Hour), WithClock(clockAt(0)))
+	assert.Nil(err)
+
+	maxEvents := func(n int) Option {
+		return func(w *Watermeter) error {
+			w.MaxEvents = n
+			return nil
+		}
+	}
+
+	// A valid batch commits together.
+	assert.Nil(wm.Reconfigure(WithTimeout(10*time.Minute), maxEvents(50), WithCoalesceWindow(time.Second)))
+	assert.Equal(10*time.Minute, wm.Timeout)
+	assert.Equal(50, wm.MaxEvents)
+	assert.Equal(time.Second, wm.CoalesceWindow)
+	assert.Equal("main", wm.Name)
+
+	// Invalid combinations roll back every change in the batch.
+	assert.Equal(ErrInvalidConfig, wm.Reconfigure(maxEvents(10), WithCoalesceWindow(time.Hour)))
+	assert.Equal(ErrInvalidConfig, wm.Reconfigure(WithTimeout(time.Minute), maxEvents(-1)))
+	bad := errors.New("bad option")
+	assert.Equal(bad, wm.Reconfigure(maxEvents(10), func(*Watermeter) error { return bad }))
+	assert.Equal(ErrNotReconfigurable, wm.Reconfigure(maxEvents(10), WithName("other")))
+	pool := NewPool(1, 1)
+	defer pool.Close()
+	assert.Equal(ErrNotReconfigurable, wm.Reconfigure(maxEvents(10), WithPool(pool)))
+
+	assert.Equal(10*time.Minute, wm.Timeout)
+	assert.Equal(50, wm.MaxEvents)
+	assert.Equal(time.Second, wm.CoalesceWindow)
+	assert.Equal("main", wm.Name)
+	assert.Nil(wm.Pool)
+
+	assert.Nil(wm.Reconfigure(WithTimeout(0)))
+	assert.Equal(DefaultTimeout, wm.Timeout)
+}
+
+func TestReconfigurePrunes(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := time.Date(2016, time.December, 25, 1, 0, 0, 0, time.UTC)
+	wm, err := New(0, WithTimeout(time.Hour), WithClock(func() time.Time { return clock }))
+	assert.Nil(err)
+	for i := 1; i <= 10; i++ {
+		clock = clock.Add(time.Minute)
+		wm.Update(1000)
+	}
+	assert.Equal(11, wm.events.Len())
+
+	// A lower MaxEvents holds as soon as Reconfigure returns.
+	maxEvents := func(w *Watermeter) error {
+		w.MaxEvents = 5
+		return nil
+	}
+	assert.Nil(wm.Reconfigure(maxEvents))
+	assert.Equal(5, wm.events.Len())
+	assert.Nil(wm.Validate())
+
+	// So does a shorter Timeout, keeping the boundary anchor.
+	assert.Nil(wm.Reconfigure(WithTimeout(150 * time.Second)))
+	assert.Equal(4, wm.events.Len())
+	assert.Nil(wm.Validate())
+	assert.Equal(uint64(10), wm.GetGallons())
+}
+
+// settings formats every field of w, exported or not, for comparison.
+func settings(w *Watermeter) string {
+	v := reflect.ValueOf(w).Elem()
+	var rv strings.Builder
+	for i := 0; i < v.NumField(); i++ {
+		fmt.Fprintf(&rv, "%s: %v\n", v.Type().Field(i).Name, v.Field(i))
+	}
+	return rv.String()
+}
+
+// exportedOptions returns the names of the exported functions of the package
+// that return an Option.
+func exportedOptions(t *testing.T) []string {
+	files, err := filepath.Glob("*.go")
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	var rv []string
+	fset := token.NewFileSet()
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(fset, file, nil, 0)
+		if nil != err {
+			t.Fatal(err)
+		}
+		for _, decl := range f.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if false == ok || nil != fn.Recv || false == fn.Name.IsExported() || nil == fn.Type.Results || 1 != len(fn.Type.Results.List) {
+				continue
+			}
+			if result, ok := fn.Type.Results.List[0].Type.(*ast.Ident); ok && "Option" == result.Name {
+				rv = append(rv, fn.Name.Name)
+			}
+		}
+	}
+	return rv
+}
+
+func TestReconfigureEveryOption(t *testing.T) {
+	assert := assert.New(t)
+
+	s := NewScheduler(time.Hour)
+	defer s.Close()
+	pool := NewPool(1, 1)
+	defer pool.Close()
+
+	options := map[string]Option{
+		"WithMaxMemory":      WithMaxMemory(1 << 20),
+		"WithExpectedRate":   WithExpectedRate(60),
+		"WithName":           WithName("main"),
+		"WithTimeout":        WithTimeout(10 * time.Minute),
+		"WithUsage":          WithUsage(func(uint64, float64) {}),
+		"WithUsageEx":        WithUsageEx(func(UsageEvent) {}),
+		"WithChange":         WithChange(func() {}),
+		"WithOnError":        WithOnError(func(error) {}),
+		"WithPool":           WithPool(pool),
+		"WithLogger":         WithLogger(slog.Default()),
+		"WithCoalesceWindow": WithCoalesceWindow(time.Second),
+		"WithContext":        WithContext(context.Background()),
+		"WithMaxInitial":     WithMaxInitial(1000),
+		"WithUnitsPerGallon": WithUnitsPerGallon(450),
+		"WithHeartbeat":      WithHeartbeat(time.Minute),
+		"WithFlowThresholds": WithFlowThresholds(0.5, 5),
+		"WithClock":          WithClock(clockAt(0)),
+		"WithAutoPersist":    WithAutoPersist(filepath.Join(t.TempDir(), "meter"), time.Minute),
+		"WithScheduler":      WithScheduler(s),
+	}
+
+	// Every exported option is covered.
+	names := exportedOptions(t)
+	assert.Equal(len(options), len(names))
+	for _, name := range names {
+		assert.Contains(options, name)
+	}
+
+	for name, opt := range options {
+		// Reconfigure may only accept an option whose settings it copies.
+		set, copied := new(Watermeter), new(Watermeter)
+		assert.Nil(opt(set), name)
+		assert.NotEqual(settings(new(Watermeter)), settings(set), name)
+		copyReconfigurable(copied, set)
+		reconfigurable := settings(set) == settings(copied)
+
+		wm, err := New(0, WithClock(clockAt(0)))
+		assert.Nil(err)
+		err = wm.Reconfigure(opt)
+		if reconfigurable {
+			assert.Nil(err, name)
+		} else {
+			assert.Equal(ErrNotReconfigurable, err, name)
+		}
+		wm.Close()
+	}
+}
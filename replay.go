@@ -0,0 +1,90 @@
+package watermeter
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// A FlowSample is the state of a watermeter at a point in time.
+type FlowSample struct {
+	// Time is the time of the sample.
+	Time time.Time
+
+	// Total is the running total in 1/1000 gallon units at Time.
+	Total uint64
+
+	// Flow is the average flow rate (gallons/min) over the interval
+	// ending at Time.
+	Flow float64
+}
+
+// ReplayPulses replays a log of raw pulses through a fresh watermeter and
+// returns the flow trace sampled at each interval boundary.  Each line of the
+// log is a CSV record whose first field is the RFC 3339 timestamp of a pulse;
+// any further fields are ignored.  Each pulse is worth unitsPerPulse 1/1000
+// gallon units.  Pulses must be in chronological order.  The first interval
+// starts at the first pulse and a pulse that lands exactly on a boundary is
+// counted in the interval that starts there.
+func ReplayPulses(r io.Reader, unitsPerPulse uint, interval time.Duration) ([]FlowSample, error) {
+	if 0 >= interval {
+		return nil, errors.New("watermeter: replay interval must be positive")
+	}
+
+	var clock, next time.Time
+	var samples []FlowSample
+	var last uint64
+
+	w := &Watermeter{
+		Timeout: interval,
+		now:     func() time.Time { return clock },
+	}
+	sample := func() {
+		samples = append(samples, FlowSample{
+			Time:  next,
+			Total: w.total,
+			Flow:  float64(w.total-last) / 1000 / interval.Minutes(),
+		})
+		last = w.total
+		next = next.Add(interval)
+	}
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	started := false
+	for count := 1; ; count++ {
+		record, err := reader.Read()
+		if io.EOF == err {
+			break
+		}
+		if nil != err {
+			return nil, err
+		}
+
+		t, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(record[0]))
+		if nil != err {
+			return nil, fmt.Errorf("watermeter: replay record %d: %v", count, err)
+		}
+
+		if false == started {
+			clock = t
+			w.Init(0)
+			next = t.Add(interval)
+			started = true
+		}
+		for false == t.Before(next) {
+			sample()
+		}
+		clock = t
+		w.UpdateAt(t, unitsPerPulse)
+	}
+
+	if started {
+		sample()
+	}
+
+	return samples, nil
+}
@@ -0,0 +1,40 @@
+package watermeter
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReplayPulses(t *testing.T) {
+	assert := assert.New(t)
+
+	log := "2016-12-25T01:00:00Z\n" +
+		"2016-12-25T01:00:30Z,extra\n" +
+		"2016-12-25T01:01:00Z\n" +
+		"2016-12-25T01:01:30Z\n" +
+		"2016-12-25T01:02:10Z\n"
+
+	samples, err := ReplayPulses(strings.NewReader(log), 500, time.Minute)
+	assert.Nil(err)
+	assert.Equal([]FlowSample{
+		{Time: time.Date(2016, time.December, 25, 1, 1, 0, 0, time.UTC), Total: 1000, Flow: 1.0},
+		{Time: time.Date(2016, time.December, 25, 1, 2, 0, 0, time.UTC), Total: 2000, Flow: 1.0},
+		{Time: time.Date(2016, time.December, 25, 1, 3, 0, 0, time.UTC), Total: 2500, Flow: 0.5},
+	}, samples)
+}
+
+func TestReplayPulsesErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	samples, err := ReplayPulses(strings.NewReader(""), 500, time.Minute)
+	assert.Nil(err)
+	assert.Nil(samples)
+
+	_, err = ReplayPulses(strings.NewReader("2016-12-25T01:00:00Z\n"), 500, 0)
+	assert.NotNil(err)
+
+	_, err = ReplayPulses(strings.NewReader("2016-12-25T01:00:00Z\nbogus\n"), 500, time.Minute)
+	assert.NotNil(err)
+}
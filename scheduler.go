@@ -0,0 +1,137 @@
+package watermeter
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A Scheduler runs the idle checks, heartbeats and automatic persistence of
+// many watermeters from a single goroutine, rather than each meter starting
+// its own.  A gateway with thousands of mostly idle meters should share one.
+type Scheduler struct {
+	mutex  sync.Mutex
+	meters map[*Watermeter]*scheduled
+	stop   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// scheduled is the servicing state of a watermeter.
+type scheduled struct {
+	w       *Watermeter
+	next    time.Time
+	removed atomic.Bool
+}
+
+// NewScheduler creates a Scheduler that services its watermeters every
+// interval.  The interval replaces each meter's IdleCheckInterval and bounds
+// how precisely its persist interval is kept.
+func NewScheduler(interval time.Duration) *Scheduler {
+	if 0 >= interval {
+		interval = time.Second
+	}
+
+	s := &Scheduler{
+		meters: make(map[*Watermeter]*scheduled),
+		stop:   make(chan struct{}),
+	}
+	s.wg.Add(1)
+	go s.run(interval)
+	return s
+}
+
+// run services the watermeters every interval until Close.
+func (s *Scheduler) run(interval time.Duration) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-ticker.C:
+			s.service(now)
+		}
+	}
+}
+
+// service checks each watermeter for idleness, adds its heartbeat and
+// persists those that are due.  The meters are serviced without the lock, so
+// a callback may close its meter and a slow save doesn't hold up add or
+// remove.  Each step is skipped for a meter removed in the meantime.
+func (s *Scheduler) service(now time.Time) {
+	s.mutex.Lock()
+	meters := make([]*scheduled, 0, len(s.meters))
+	for _, m := range s.meters {
+		meters = append(meters, m)
+	}
+	s.mutex.Unlock()
+
+	for _, m := range meters {
+		w := m.w
+		if 0 < w.IdleTimeout && false == m.removed.Load() {
+			w.checkIdle()
+		}
+		if 0 < w.HeartbeatInterval && false == m.removed.Load() {
+			w.heartbeat()
+		}
+		if "" != w.persistPath && 0 < w.persistInterval && false == now.Before(m.next) && false == m.removed.Load() {
+			w.persist()
+			m.next = now.Add(w.persistInterval)
+		}
+	}
+}
+
+// add starts servicing the watermeter.
+func (s *Scheduler) add(w *Watermeter) {
+	s.mutex.Lock()
+	s.meters[w] = &scheduled{w: w, next: time.Now().Add(w.persistInterval)}
+	s.mutex.Unlock()
+}
+
+// remove stops servicing the watermeter and returns true if it was being
+// serviced.  No further step is started for it once remove returns, though a
+// step already under way completes.
+func (s *Scheduler) remove(w *Watermeter) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	m, ok := s.meters[w]
+	if ok {
+		m.removed.Store(true)
+		delete(s.meters, w)
+	}
+	return ok
+}
+
+// Len gets the number of watermeters being serviced.
+func (s *Scheduler) Len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return len(s.meters)
+}
+
+// Close stops the Scheduler and waits for it to exit.  The watermeters are no
+// longer serviced but are otherwise unaffected.
+func (s *Scheduler) Close() {
+	s.mutex.Lock()
+	select {
+	case <-s.stop:
+	default:
+		close(s.stop)
+	}
+	s.mutex.Unlock()
+
+	s.wg.Wait()
+}
+
+// WithScheduler sets the Scheduler that services the watermeter.
+func WithScheduler(s *Scheduler) Option {
+	return func(w *Watermeter) error {
+		w.Scheduler = s
+		return nil
+	}
+}
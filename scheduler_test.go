@@ -0,0 +1,179 @@
+package watermeter
+
+import (
+	"github.com/stretchr/testify/assert"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLite(t *testing.T) {
+	assert := assert.New(t)
+
+	flows := make(chan float64, 4)
+	wm := Watermeter{
+		Timeout: time.Hour,
+		Lite:    true,
+		Usage:   func(gallons uint64, flow float64) { flows <- flow },
+	}
+	setNow(&wm, 0)
+	wm.Init(0)
+	for min := 1; min <= 4; min++ {
+		setNow(&wm, min)
+		wm.Update(500)
+	}
+
+	assert.Equal(1, wm.events.Len())
+	assert.Equal(uint64(2), wm.GetGallons())
+	assert.Equal(at(4, 0), wm.GetLastUpdate())
+	assert.Equal(0.0, wm.GetFlow(10*time.Minute))
+	assert.Nil(wm.Validate())
+
+	for i := 0; i < 2; i++ {
+		select {
+		case flow := <-flows:
+			assert.Equal(0.5, flow)
+		case <-time.After(time.Second):
+			assert.Fail("Usage was not called")
+		}
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	assert := assert.New(t)
+
+	s := NewScheduler(time.Millisecond)
+	defer s.Close()
+
+	idle := make(chan string, 1)
+	before := runtime.NumGoroutine()
+	meters := make([]*Watermeter, 100)
+	var late int64 = -1
+	for i := range meters {
+		i, name := i, strconv.Itoa(i)
+		meters[i] = &Watermeter{
+			Name:        name,
+			Timeout:     time.Hour,
+			IdleTimeout: time.Hour,
+			Scheduler:   s,
+			Idle:        func(time.Time) { idle <- name },
+			now: func() time.Time {
+				if int64(i) == atomic.LoadInt64(&late) {
+					return at(61, 0)
+				}
+				return at(0, 0)
+			},
+		}
+		meters[i].Init(0)
+	}
+	assert.Equal(100, s.Len())
+	assert.LessOrEqual(runtime.NumGoroutine(), before+1)
+
+	// Only the meter whose clock moved on goes idle.
+	atomic.StoreInt64(&late, 7)
+	select {
+	case name := <-idle:
+		assert.Equal("7", name)
+	case <-time.After(time.Second):
+		assert.Fail("Idle was not called")
+	}
+
+	for _, w := range meters {
+		w.Close()
+	}
+	assert.Equal(0, s.Len())
+}
+
+func TestSchedulerCloseFromCallback(t *testing.T) {
+	assert := assert.New(t)
+
+	s := NewScheduler(time.Millisecond)
+	defer s.Close()
+
+	closed := make(chan struct{})
+	var late atomic.Bool
+	wm := &Watermeter{
+		Timeout:       time.Hour,
+		IdleTimeout:   time.Minute,
+		Scheduler:     s,
+		SyncCallbacks: true,
+		now: func() time.Time {
+			if late.Load() {
+				return at(2, 0)
+			}
+			return at(0, 0)
+		},
+	}
+	wm.Idle = func(time.Time) {
+		wm.Close()
+		close(closed)
+	}
+	wm.Init(0)
+	late.Store(true)
+
+	// The callback closes its meter from within the Scheduler.
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		assert.Fail("Idle did not close the meter")
+	}
+	assert.Equal(0, s.Len())
+
+	// The Scheduler carries on servicing the other meters.
+	other := &Watermeter{Timeout: time.Hour, IdleTimeout: time.Hour, Scheduler: s}
+	other.Init(0)
+	assert.Equal(1, s.Len())
+	other.Close()
+	assert.Equal(0, s.Len())
+}
+
+func benchmarkMeters(b *testing.B, lite bool) {
+	const n = 10000
+
+	s := NewScheduler(time.Minute)
+	defer s.Close()
+
+	for i := 0; i < b.N; i++ {
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		goroutines := runtime.NumGoroutine()
+
+		clock := time.Date(2016, time.December, 25, 1, 0, 0, 0, time.UTC)
+		now := func() time.Time { return clock }
+		meters := make([]*Watermeter, n)
+		for j := range meters {
+			w := &Watermeter{Timeout: time.Hour, IdleTimeout: time.Hour, now: now}
+			if lite {
+				w.Lite = true
+				w.Scheduler = s
+			}
+			meters[j] = w.Init(0)
+		}
+		for k := 0; k < 50; k++ {
+			clock = clock.Add(time.Second)
+			for _, w := range meters {
+				w.Update(100)
+			}
+		}
+
+		runtime.GC()
+		runtime.ReadMemStats(&after)
+		b.ReportMetric(float64(after.HeapAlloc-before.HeapAlloc)/n, "bytes/meter")
+		b.ReportMetric(float64(runtime.NumGoroutine()-goroutines)/n, "goroutines/meter")
+
+		for _, w := range meters {
+			w.Close()
+		}
+	}
+}
+
+func BenchmarkMetersFull(b *testing.B) {
+	benchmarkMeters(b, false)
+}
+
+func BenchmarkMetersLite(b *testing.B) {
+	benchmarkMeters(b, true)
+}
@@ -0,0 +1,91 @@
+package watermeter
+
+import (
+	"time"
+)
+
+// A UsageReport is what an update would do to a watermeter.
+type UsageReport struct {
+	// Time is the time the update would be recorded at.
+	Time time.Time
+
+	// Total is the running total in 1/1000 gallon units after the update.
+	Total uint64
+
+	// Usage is true if the update would cross a boundary and call Usage.
+	Usage bool
+
+	// Gallons and Flow are what Usage would be called with.
+	Gallons uint64
+	Flow    float64
+
+	// Stuck is true if the update would find the meter stuck.  The
+	// AlertCooldown isn't consulted.
+	Stuck bool
+}
+
+// Simulate reports what Update(mGallons) would do now without doing it: the
+// running total, history and alarm state are left alone and no callbacks are
+// called.  It is meant for checking a new sensor's calibration against a
+// reference.  MaxSingleUpdate isn't checked.
+func (w *Watermeter) Simulate(mGallons uint) UsageReport {
+	now := w.now()
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	e := *w.events.Front().Value.(*entry)
+	if now.Before(e.time) {
+		now = e.time
+	}
+
+	rv := UsageReport{Time: now, Total: w.total + uint64(mGallons)}
+	coalesces := w.coalesces(now, "")
+	if coalesces {
+		rv.Time = e.time
+	}
+
+	increment := w.usageIncrement()
+	rv.Gallons = rv.Total / increment
+	rv.Usage = rv.Gallons > w.total/increment
+	if rv.Usage && 0 < w.UsageFlowWindow {
+		rv.Flow = w.reportedFlow(w.simulatedFlow(now, rv.Total, coalesces))
+	} else if rv.Usage && (false == w.SuppressSeedFlow || false == w.seedGallon) {
+		rv.Flow = w.reportedFlow(rate(rv.Total-w.lastGallon.total, rv.Time.Sub(w.lastGallon.time)))
+	}
+	if false == rv.Usage {
+		rv.Gallons = 0
+	}
+
+	rv.Stuck = 0 == mGallons && 0 < w.StuckTimeout && false == w.stuck && w.StuckTimeout <= now.Sub(w.lastChange)
+
+	return rv
+}
+
+// simulatedFlow gets the flow over UsageFlowWindow that an update to total at
+// now would report, measured over the history as the update would leave it
+// before pruning.  The history is put back before returning.  The caller
+// must hold the mutex.
+func (w *Watermeter) simulatedFlow(now time.Time, total uint64, coalesces bool) float64 {
+	front := w.events.Front().Value.(*entry)
+	saved, savedTotal := *front, w.total
+	w.total = total
+
+	var flow float64
+	switch {
+	case w.Lite:
+		front.time, front.total = now, total
+		flow = w.flow(now, w.UsageFlowWindow)
+	case coalesces:
+		front.total = total
+		flow = w.flow(now, w.UsageFlowWindow)
+	default:
+		item := w.events.PushFront(&entry{time: now, total: total})
+		flow = w.flow(now, w.UsageFlowWindow)
+		w.events.Remove(item)
+	}
+
+	*front = saved
+	w.total = savedTotal
+	return flow
+}
@@ -0,0 +1,110 @@
+package watermeter
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestSimulate(t *testing.T) {
+	assert := assert.New(t)
+
+	type usage struct {
+		gallons uint64
+		flow    float64
+	}
+	calls := make(chan usage, 4)
+	wm := Watermeter{
+		Timeout:      time.Hour,
+		StuckTimeout: 5 * time.Minute,
+		Usage:        func(gallons uint64, flow float64) { calls <- usage{gallons, flow} },
+	}
+	setNow(&wm, 0)
+	wm.Init(500)
+	setNow(&wm, 1)
+	wm.Update(250)
+
+	// Doesn't cross a boundary.
+	setNow(&wm, 2)
+	rv := wm.Simulate(100)
+	assert.Equal(UsageReport{Time: at(2, 0), Total: 850}, rv)
+	assert.Equal(uint64(750), wm.total)
+	assert.Equal(2, wm.events.Len())
+
+	// Crosses one, and matches the real update.
+	rv = wm.Simulate(1500)
+	assert.Equal(uint64(750), wm.total)
+	assert.Equal(2, wm.events.Len())
+	wm.Update(1500)
+	assert.Equal(wm.total, rv.Total)
+	assert.True(rv.Usage)
+	select {
+	case got := <-calls:
+		assert.Equal(usage{rv.Gallons, rv.Flow}, got)
+		assert.Equal(uint64(2), got.gallons)
+	case <-time.After(time.Second):
+		assert.Fail("Usage was not called")
+	}
+
+	// Stuck.
+	setNow(&wm, 8)
+	assert.True(wm.Simulate(0).Stuck)
+	assert.False(wm.Simulate(10).Stuck)
+	assert.False(wm.stuck)
+}
+
+func TestSimulateHeartbeat(t *testing.T) {
+	assert := assert.New(t)
+
+	flows := make(chan float64, 2)
+	wm := Watermeter{
+		Timeout:           time.Hour,
+		CoalesceWindow:    2 * time.Minute,
+		HeartbeatInterval: time.Minute,
+		SyncCallbacks:     true,
+		Usage:             func(gallons uint64, flow float64) { flows <- flow },
+	}
+	setNow(&wm, 0)
+	wm.Init(0)
+	wm.Close()
+	setNow(&wm, 1)
+	wm.Update(1000)
+	<-flows
+	setNow(&wm, 3)
+	wm.heartbeat()
+
+	// The update isn't folded into the heartbeat, as by Update.
+	setNow(&wm, 4)
+	rv := wm.Simulate(1000)
+	assert.Equal(at(4, 0), rv.Time)
+	wm.Update(1000)
+	assert.Equal(at(4, 0), wm.events.Front().Value.(*entry).time)
+	assert.Equal(rv.Flow, <-flows)
+	assert.InDelta(1.0/3, rv.Flow, 1e-9)
+}
+
+func TestSimulateAnchor(t *testing.T) {
+	assert := assert.New(t)
+
+	flows := make(chan float64, 1)
+	wm := Watermeter{
+		Timeout:         10 * time.Minute,
+		UsageFlowWindow: 5 * time.Minute,
+		SyncCallbacks:   true,
+		Usage:           func(gallons uint64, flow float64) { flows <- flow },
+	}
+	setNow(&wm, 0)
+	wm.Init(0)
+	setNow(&wm, 9)
+	wm.Update(500)
+
+	// The window starts between the seed, past Timeout, and the event at 9.
+	setNow(&wm, 12)
+	rv := wm.Simulate(600)
+	assert.Equal(2, wm.events.Len())
+	assert.Equal(uint64(500), wm.total)
+	wm.Update(600)
+	assert.True(rv.Usage)
+	assert.Equal(rv.Flow, <-flows)
+	assert.InDelta((1100-500*7/9)/5000.0, rv.Flow, 1e-9)
+}
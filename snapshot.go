@@ -0,0 +1,75 @@
+package watermeter
+
+import (
+	"time"
+)
+
+// A Snapshot is an immutable copy of the state of a watermeter that may be
+// used without any further locking.
+type Snapshot struct {
+	// Name is the name of the watermeter.
+	Name string
+
+	// Total is the running total in 1/1000 gallon units.
+	Total uint64
+
+	// LastUpdate is the time of the last update, as by GetLastUpdate.
+	LastUpdate time.Time
+
+	// Flow1m is the flow rate (gallons/min) over the last minute.
+	Flow1m float64
+
+	// Flow5m is the flow rate (gallons/min) over the last 5 minutes.
+	Flow5m float64
+
+	// EventCount is the number of retained events.
+	EventCount int
+}
+
+// Snapshot captures the state of the watermeter atomically.
+func (w *Watermeter) Snapshot() Snapshot {
+	now := w.now()
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return Snapshot{
+		Name:       w.Name,
+		Total:      w.total,
+		LastUpdate: w.lastUpdate,
+		Flow1m:     w.flow(now, time.Minute),
+		Flow5m:     w.flow(now, 5*time.Minute),
+		EventCount: w.events.Len(),
+	}
+}
+
+// Metrics gets the state of the watermeter as named values for pushing to
+// any metrics backend, computed atomically:
+//
+//	total_gallons  the running total in gallons
+//	flow_1m_gpm    the flow rate over the last minute
+//	flow_5m_gpm    the flow rate over the last 5 minutes
+//	peak_flow_gpm  the highest flow rate between retained events
+//	event_count    the number of retained events
+func (w *Watermeter) Metrics() map[string]float64 {
+	now := w.now()
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	peak := 0.0
+	oldest := w.events.Back().Value.(*entry).time
+	for _, r := range w.intervalRates(now, now.Sub(oldest)) {
+		if peak < r {
+			peak = r
+		}
+	}
+
+	return map[string]float64{
+		"total_gallons": float64(w.total) / 1000,
+		"flow_1m_gpm":   w.flow(now, time.Minute),
+		"flow_5m_gpm":   w.flow(now, 5*time.Minute),
+		"peak_flow_gpm": peak,
+		"event_count":   float64(w.events.Len()),
+	}
+}
@@ -0,0 +1,67 @@
+package watermeter
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSnapshot(t *testing.T) {
+	assert := assert.New(t)
+
+	wm := Watermeter{Name: "main", Timeout: time.Hour}
+	setNow(&wm, 0)
+	wm.Init(0)
+
+	s := wm.Snapshot()
+	assert.Equal(Snapshot{
+		Name:       "main",
+		LastUpdate: time.Date(2016, time.December, 25, 1, 0, 0, 0, time.UTC),
+		EventCount: 1,
+	}, s)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 250; j++ {
+				wm.Update(1000)
+			}
+		}()
+	}
+
+	for i := 0; i < 200; i++ {
+		s := wm.Snapshot()
+		// Every update adds 1000 units and an event at the same instant.
+		assert.Equal(uint64(s.EventCount-1)*1000, s.Total)
+		assert.Equal(float64(s.Total)/1000, s.Flow1m)
+		assert.Equal(float64(s.Total)/1000/5, s.Flow5m)
+	}
+	wg.Wait()
+
+	s = wm.Snapshot()
+	assert.Equal(uint64(1000000), s.Total)
+	assert.Equal(1001, s.EventCount)
+}
+
+func TestMetrics(t *testing.T) {
+	assert := assert.New(t)
+
+	wm := Watermeter{Timeout: time.Hour}
+	setNow(&wm, 0)
+	wm.Init(10000)
+	for i, v := range []uint{1000, 3000, 500, 2000, 1500} {
+		setNow(&wm, i+1)
+		wm.Update(v)
+	}
+
+	assert.Equal(map[string]float64{
+		"total_gallons": 18,
+		"flow_1m_gpm":   1.5,
+		"flow_5m_gpm":   8.0 / 5,
+		"peak_flow_gpm": 3,
+		"event_count":   6,
+	}, wm.Metrics())
+}
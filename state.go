@@ -0,0 +1,95 @@
+package watermeter
+
+import (
+	"math"
+	"time"
+)
+
+// A State is a coarse classification of the flow through a watermeter.
+type State int
+
+const (
+	// StateStopped is no flow.
+	StateStopped State = iota
+
+	// StateTrickle is a flow below LowFlow.
+	StateTrickle
+
+	// StateNormal is a steady flow between LowFlow and HighFlow.
+	StateNormal
+
+	// StateHigh is a flow of at least HighFlow.
+	StateHigh
+
+	// StateIncreasing is a flow rising sharply.
+	StateIncreasing
+
+	// StateDecreasing is a flow falling sharply.
+	StateDecreasing
+)
+
+// String returns the name of the state.
+func (s State) String() string {
+	switch s {
+	case StateStopped:
+		return "stopped"
+	case StateTrickle:
+		return "trickle"
+	case StateNormal:
+		return "normal"
+	case StateHigh:
+		return "high"
+	case StateIncreasing:
+		return "increasing"
+	case StateDecreasing:
+		return "decreasing"
+	}
+	return "unknown"
+}
+
+// defaultFlowStateWindow is the FlowStateWindow used when none is set.
+const defaultFlowStateWindow = time.Minute
+
+// flowStateChange is the fraction the flow must change by from the previous
+// window to be increasing or decreasing.
+const flowStateChange = 0.25
+
+// FlowState classifies the current flow for a status badge.  The flow over
+// the last FlowStateWindow is compared with LowFlow and HighFlow and with the
+// flow over the window before it, which it must differ from by more than a
+// quarter to be increasing or decreasing.  When several apply the first of
+// stopped, high, increasing or decreasing, trickle and normal wins, so a
+// flow reaching HighFlow is high however fast it rose.  A reverse flow is
+// classified by its magnitude.
+func (w *Watermeter) FlowState() State {
+	now := w.now()
+
+	w.mutex.Lock()
+	events := w.entries()
+	low, high, window := w.LowFlow, w.HighFlow, w.FlowStateWindow
+	w.mutex.Unlock()
+
+	if 0 >= window {
+		window = defaultFlowStateWindow
+	}
+
+	end := interpolate(events, now)
+	mid := interpolate(events, now.Add(-window))
+	start := interpolate(events, now.Add(-2*window))
+	current := math.Abs(rate(end-mid, window))
+	previous := math.Abs(rate(mid-start, window))
+
+	switch {
+	case 0 == current:
+		return StateStopped
+	case 0 < high && high <= current:
+		return StateHigh
+	case previous*(1+flowStateChange) < current:
+		return StateIncreasing
+	case current < previous*(1-flowStateChange):
+		return StateDecreasing
+	case 0 < low && current < low:
+		return StateTrickle
+	}
+	return StateNormal
+}
@@ -0,0 +1,64 @@
+package watermeter
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestFlowState(t *testing.T) {
+	assert := assert.New(t)
+
+	wm := Watermeter{Timeout: time.Hour, LowFlow: 0.5, HighFlow: 5}
+	setNow(&wm, 0)
+	wm.Init(0)
+	assert.Equal(StateStopped, wm.FlowState())
+
+	// Each new rate is increasing for its first minute, then holds steady.
+	for i, want := range []State{
+		StateIncreasing, StateTrickle,
+		StateIncreasing, StateNormal,
+		StateHigh, StateHigh,
+		StateDecreasing,
+	} {
+		setNow(&wm, i+1)
+		wm.Update([]uint{250, 250, 2000, 2000, 8000, 8000, 4000}[i])
+		assert.Equal(want, wm.FlowState(), "minute %d", i+1)
+	}
+
+	setNow(&wm, 9)
+	assert.Equal(StateStopped, wm.FlowState())
+
+	assert.Equal("stopped", StateStopped.String())
+	assert.Equal("decreasing", StateDecreasing.String())
+	assert.Equal("unknown", State(-1).String())
+}
+
+func TestFlowStateReconfigure(t *testing.T) {
+	wm := Watermeter{Timeout: time.Hour}
+	wm.Init(0)
+	defer wm.Close()
+
+	// FlowState may run while the window is reconfigured.
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 1; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			wm.Reconfigure(func(w *Watermeter) error {
+				w.FlowStateWindow = time.Duration(i) * time.Second
+				return nil
+			})
+		}
+	}()
+	for i := 0; i < 1000; i++ {
+		wm.FlowState()
+	}
+	close(stop)
+	<-done
+}
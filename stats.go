@@ -0,0 +1,238 @@
+package watermeter
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// statsCache holds the sorted interval rates for a duration.
+type statsCache struct {
+	at    time.Time
+	rates []float64
+}
+
+// intervalRates returns the flow rate (gallons/min) of each interval between
+// consecutive events within the duration ending at now, oldest first.
+// Intervals without any elapsed time are skipped.  The caller must hold the
+// mutex.
+func (w *Watermeter) intervalRates(now time.Time, duration time.Duration) []float64 {
+	then := now.Add(-duration)
+
+	var rates []float64
+	for item := w.events.Front(); nil != item && nil != item.Next(); item = item.Next() {
+		b := item.Value.(*entry)
+		a := item.Next().Value.(*entry)
+		if a.time.Before(then) {
+			break
+		}
+		if span := b.time.Sub(a.time); 0 < span {
+			rates = append(rates, rate(b.total-a.total, span))
+		}
+	}
+
+	for i, j := 0, len(rates)-1; i < j; i, j = i+1, j-1 {
+		rates[i], rates[j] = rates[j], rates[i]
+	}
+	return rates
+}
+
+// sortedRates returns the interval rates over the duration in ascending
+// order, reusing a computation newer than StatsCacheTTL.  The result must not
+// be modified.
+func (w *Watermeter) sortedRates(duration time.Duration) []float64 {
+	now := w.now()
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if cached, ok := w.stats[duration]; ok && now.Sub(cached.at) < w.StatsCacheTTL {
+		return cached.rates
+	}
+
+	rates := w.intervalRates(now, duration)
+	sort.Float64s(rates)
+	w.statsComputed++
+
+	if 0 < w.StatsCacheTTL {
+		if nil == w.stats {
+			w.stats = make(map[time.Duration]statsCache)
+		}
+		w.stats[duration] = statsCache{at: now, rates: rates}
+	}
+	return rates
+}
+
+// FlowPercentile gets the p-th percentile (0 to 100) of the flow rates
+// (gallons/min) of the intervals between events over the specified duration,
+// interpolating between the closest ranks.  It returns 0 if there are no
+// intervals.
+func (w *Watermeter) FlowPercentile(duration time.Duration, p float64) float64 {
+	rates := w.sortedRates(duration)
+	if 0 == len(rates) {
+		return 0
+	}
+	if 0 > p {
+		p = 0
+	} else if 100 < p {
+		p = 100
+	}
+
+	rank := p / 100 * float64(len(rates)-1)
+	i := int(rank)
+	if i == len(rates)-1 {
+		return rates[i]
+	}
+	return rates[i] + (rates[i+1]-rates[i])*(rank-float64(i))
+}
+
+// FlowMedian gets the median flow rate (gallons/min) of the intervals
+// between events over the specified duration.
+func (w *Watermeter) FlowMedian(duration time.Duration) float64 {
+	return w.FlowPercentile(duration, 50)
+}
+
+// FlowHistogram counts the flow rates (gallons/min) of the intervals between
+// events over the specified duration into the buckets separated by the
+// ascending bounds.  Bucket i counts rates below bounds[i] and not below
+// bounds[i-1]; the final bucket counts rates not below the last bound.
+func (w *Watermeter) FlowHistogram(duration time.Duration, bounds []float64) []int {
+	rv := make([]int, len(bounds)+1)
+	for _, r := range w.sortedRates(duration) {
+		rv[sort.Search(len(bounds), func(i int) bool { return r < bounds[i] })]++
+	}
+	return rv
+}
+
+// FlowCV gets the coefficient of variation, the standard deviation divided by
+// the mean, of the flow rates of the intervals between events over the
+// specified duration.  A low value means steady flow and a high value means
+// bursty flow.  It returns 0 with fewer than 2 intervals or no flow.
+func (w *Watermeter) FlowCV(duration time.Duration) float64 {
+	now := w.now()
+
+	w.mutex.Lock()
+	rates := w.intervalRates(now, duration)
+	w.mutex.Unlock()
+
+	if 2 > len(rates) {
+		return 0
+	}
+
+	var sum float64
+	for _, r := range rates {
+		sum += r
+	}
+	mean := sum / float64(len(rates))
+	if 0 == mean {
+		return 0
+	}
+
+	var squares float64
+	for _, r := range rates {
+		squares += (r - mean) * (r - mean)
+	}
+	return math.Sqrt(squares/float64(len(rates))) / mean
+}
+
+// TrimmedMeanFlow gets the mean flow rate (gallons/min) of the intervals
+// between events over the specified duration after dropping trimFraction of
+// the intervals from each end of the sorted rates, so a single bounce or
+// long gap doesn't skew it.  trimFraction must be in [0, 0.5); it returns 0
+// otherwise or if there are no intervals.
+func (w *Watermeter) TrimmedMeanFlow(duration time.Duration, trimFraction float64) float64 {
+	if false == (0 <= trimFraction && trimFraction < 0.5) {
+		return 0
+	}
+
+	rates := w.sortedRates(duration)
+	trim := int(float64(len(rates)) * trimFraction)
+	rates = rates[trim : len(rates)-trim]
+	if 0 == len(rates) {
+		return 0
+	}
+
+	var sum float64
+	for _, r := range rates {
+		sum += r
+	}
+	return sum / float64(len(rates))
+}
+
+// An IntervalStat describes the interval between two consecutive events.
+type IntervalStat struct {
+	// Start and End are the times of the older and newer event.
+	Start time.Time
+	End   time.Time
+
+	// Volume is the volume in 1/1000 gallon units recorded at End.
+	Volume uint64
+
+	// Flow is the flow rate (gallons/min) over the interval, or 0 if no time
+	// elapsed.
+	Flow float64
+
+	// Fraction is the share of the volume of all the returned intervals.
+	Fraction float64
+}
+
+// IntervalBreakdown decomposes the flow over the specified duration into the
+// intervals between the consecutive events within it, oldest first, so the
+// interval that skews an average can be found.  Unlike the interval rates
+// behind the other statistics, intervals without elapsed time are included.
+func (w *Watermeter) IntervalBreakdown(duration time.Duration) []IntervalStat {
+	then := w.now().Add(-duration)
+
+	w.mutex.Lock()
+	events := w.entries()
+	w.mutex.Unlock()
+
+	var rv []IntervalStat
+	var sum uint64
+	for i := 1; i < len(events); i++ {
+		a, b := events[i-1], events[i]
+		if a.time.Before(then) {
+			continue
+		}
+		s := IntervalStat{Start: a.time, End: b.time, Flow: rate(b.total-a.total, b.time.Sub(a.time))}
+		if a.total < b.total {
+			s.Volume = b.total - a.total
+		}
+		sum += s.Volume
+		rv = append(rv, s)
+	}
+
+	if 0 < sum {
+		for i := range rv {
+			rv[i].Fraction = float64(rv[i].Volume) / float64(sum)
+		}
+	}
+	return rv
+}
+
+// SamplingRate gets how often updates arrived over the specified duration, in
+// events per minute, regardless of their volume.  A sudden drop suggests a
+// failing sensor even while the total looks plausible.  Heartbeats aren't
+// counted and updates coalesced into one event count once.  It returns 0 if
+// duration isn't positive.
+func (w *Watermeter) SamplingRate(duration time.Duration) float64 {
+	if 0 >= duration {
+		return 0
+	}
+	then := w.now().Add(-duration)
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	count := 0
+	for item := w.events.Front(); nil != item; item = item.Next() {
+		e := item.Value.(*entry)
+		if false == e.time.After(then) {
+			break
+		}
+		if false == e.heartbeat {
+			count++
+		}
+	}
+	return float64(count) / duration.Minutes()
+}
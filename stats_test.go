@@ -0,0 +1,188 @@
+package watermeter
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func statsMeter() *Watermeter {
+	wm := &Watermeter{Timeout: time.Hour}
+	setNow(wm, 0)
+	wm.Init(0)
+
+	// Interval rates of 1, 4, 2, 3 and 5 gallons/min.
+	for i, v := range []uint{1000, 4000, 2000, 3000, 5000} {
+		setNow(wm, i+1)
+		wm.Update(v)
+	}
+	return wm
+}
+
+func TestFlowPercentile(t *testing.T) {
+	assert := assert.New(t)
+
+	wm := statsMeter()
+	assert.Equal(1.0, wm.FlowPercentile(time.Hour, 0))
+	assert.Equal(3.0, wm.FlowMedian(time.Hour))
+	assert.Equal(4.6, wm.FlowPercentile(time.Hour, 90))
+	assert.Equal(5.0, wm.FlowPercentile(time.Hour, 100))
+	assert.Equal(5.0, wm.FlowPercentile(time.Hour, 200))
+	assert.Equal(1.0, wm.FlowPercentile(time.Hour, -1))
+
+	// Only the newest two intervals are in the window.
+	assert.Equal(4.0, wm.FlowMedian(2*time.Minute))
+
+	assert.Equal([]int{1, 2, 2}, wm.FlowHistogram(time.Hour, []float64{2, 4}))
+	assert.Equal([]int{5}, wm.FlowHistogram(time.Hour, nil))
+
+	empty := Watermeter{Timeout: time.Hour}
+	setNow(&empty, 0)
+	empty.Init(0)
+	assert.Equal(0.0, empty.FlowMedian(time.Hour))
+}
+
+func TestStatsCacheTTL(t *testing.T) {
+	assert := assert.New(t)
+
+	wm := statsMeter()
+	wm.StatsCacheTTL = time.Minute
+
+	assert.Equal(3.0, wm.FlowMedian(time.Hour))
+	assert.Equal(1, wm.statsComputed)
+
+	// Within the TTL the cached computation is used, even though new data
+	// has arrived.
+	wm.now = func() time.Time { return at(5, 30) }
+	wm.Update(0)
+	assert.Equal(3.0, wm.FlowMedian(time.Hour))
+	assert.Equal([]int{1, 2, 2}, wm.FlowHistogram(time.Hour, []float64{2, 4}))
+	assert.Equal(1, wm.statsComputed)
+
+	// Each duration is cached separately.
+	assert.Equal(3.0, wm.FlowMedian(3*time.Minute))
+	assert.Equal(2, wm.statsComputed)
+
+	// After the TTL it is computed again.
+	setNow(wm, 6)
+	assert.Equal(2.5, wm.FlowMedian(time.Hour))
+	assert.Equal(3, wm.statsComputed)
+
+	// Without a TTL every call computes.
+	wm.StatsCacheTTL = 0
+	wm.FlowMedian(time.Hour)
+	wm.FlowMedian(time.Hour)
+	assert.Equal(5, wm.statsComputed)
+}
+
+func TestFlowCV(t *testing.T) {
+	assert := assert.New(t)
+
+	steady := &Watermeter{Timeout: time.Hour}
+	bursty := &Watermeter{Timeout: time.Hour}
+	setNow(steady, 0)
+	setNow(bursty, 0)
+	steady.Init(0)
+	bursty.Init(0)
+	assert.Equal(0.0, steady.FlowCV(time.Hour))
+
+	for i, v := range []uint{1000, 1100, 900, 1000} {
+		setNow(steady, i+1)
+		steady.Update(v)
+	}
+	for i, v := range []uint{0, 4000, 0, 0} {
+		setNow(bursty, i+1)
+		bursty.Update(v)
+	}
+
+	assert.InDelta(0.0707, steady.FlowCV(time.Hour), 0.0001)
+	assert.InDelta(1.7321, bursty.FlowCV(time.Hour), 0.0001)
+	assert.True(steady.FlowCV(time.Hour) < bursty.FlowCV(time.Hour))
+
+	// A single interval is not enough.
+	assert.Equal(0.0, steady.FlowCV(time.Minute))
+}
+
+func TestTrimmedMeanFlow(t *testing.T) {
+	assert := assert.New(t)
+
+	wm := &Watermeter{Timeout: time.Hour}
+	setNow(wm, 0)
+	wm.Init(0)
+
+	// Nine intervals of 2 gallons/min and a bounce of 50.
+	for i := 1; i <= 10; i++ {
+		setNow(wm, i)
+		if 5 == i {
+			wm.Update(50000)
+		} else {
+			wm.Update(2000)
+		}
+	}
+
+	assert.InDelta(6.8, wm.TrimmedMeanFlow(time.Hour, 0), 1e-9)
+	assert.InDelta(2.0, wm.TrimmedMeanFlow(time.Hour, 0.1), 1e-9)
+	assert.InDelta(2.0, wm.TrimmedMeanFlow(time.Hour, 0.25), 1e-9)
+
+	assert.Equal(0.0, wm.TrimmedMeanFlow(time.Hour, 0.5))
+	assert.Equal(0.0, wm.TrimmedMeanFlow(time.Hour, -0.1))
+	assert.Equal(0.0, wm.TrimmedMeanFlow(0, 0.1))
+}
+
+func TestIntervalBreakdown(t *testing.T) {
+	assert := assert.New(t)
+
+	wm := statsMeter()
+	setNow(wm, 5)
+
+	// The last four intervals, by their rates of 4, 2, 3 and 5 gallons/min.
+	rv := wm.IntervalBreakdown(4 * time.Minute)
+	assert.Equal(4, len(rv))
+
+	var fractions float64
+	for i, want := range []float64{4, 2, 3, 5} {
+		assert.Equal(at(i+1, 0), rv[i].Start)
+		assert.Equal(at(i+2, 0), rv[i].End)
+		assert.Equal(uint64(want*1000), rv[i].Volume)
+		assert.Equal(want, rv[i].Flow)
+		assert.InDelta(want/14, rv[i].Fraction, 1e-9)
+		fractions += rv[i].Fraction
+	}
+	assert.InDelta(1.0, fractions, 1e-9)
+
+	assert.Equal(5, len(wm.IntervalBreakdown(time.Hour)))
+	assert.Nil(wm.IntervalBreakdown(0))
+}
+
+func TestSamplingRate(t *testing.T) {
+	assert := assert.New(t)
+
+	wm := Watermeter{Timeout: time.Hour}
+	setNow(&wm, 0)
+	wm.Init(0)
+
+	// A pulse every 15 seconds for ten minutes.
+	for sec := 15; sec <= 600; sec += 15 {
+		now := at(0, 0).Add(time.Duration(sec) * time.Second)
+		wm.now = func() time.Time { return now }
+		wm.Update(100)
+	}
+	assert.Equal(4.0, wm.SamplingRate(10*time.Minute))
+	assert.Equal(4.0, wm.SamplingRate(time.Minute))
+
+	// The sensor slows to a pulse a minute.
+	for min := 11; min <= 15; min++ {
+		setNow(&wm, min)
+		wm.Update(100)
+	}
+	assert.Equal(1.0, wm.SamplingRate(5*time.Minute))
+	assert.Equal(0.0, wm.SamplingRate(0))
+
+	// Heartbeats aren't pulses.
+	wm.HeartbeatInterval = time.Minute
+	for min := 16; min <= 20; min++ {
+		setNow(&wm, min)
+		wm.heartbeat()
+	}
+	assert.Equal(0.0, wm.SamplingRate(5*time.Minute))
+}
@@ -0,0 +1,86 @@
+package watermeter
+
+import (
+	"net"
+	"strconv"
+	"sync"
+)
+
+// An observer is an internal listener for the UsageEx events.
+type observer struct {
+	fn func(UsageEvent)
+}
+
+// observe adds fn as a listener for the UsageEx events and returns a function
+// that removes it.  The slice is replaced rather than modified so update can
+// use it after unlocking.
+func (w *Watermeter) observe(fn func(UsageEvent)) (remove func()) {
+	o := &observer{fn: fn}
+
+	w.mutex.Lock()
+	w.observers = append(w.observers[:len(w.observers):len(w.observers)], o)
+	w.mutex.Unlock()
+
+	return func() {
+		w.mutex.Lock()
+		defer w.mutex.Unlock()
+
+		var rv []*observer
+		for _, other := range w.observers {
+			if o != other {
+				rv = append(rv, other)
+			}
+		}
+		w.observers = rv
+	}
+}
+
+// StatsdSink sends the readings to the statsd collector at addr over UDP each
+// time the total crosses a gallon, or UsageIncrement, boundary.  Each packet
+// holds prefix.total:<n>|c, where n is the number of boundaries crossed since
+// the previous packet, and prefix.flow:<f>|g with the flow rate
+// (gallons/min) passed to Usage.  Sends don't block updates; a failed send is
+// dropped and reported to OnError.  stop removes the sink and closes its
+// socket, and may be called more than once.
+func (w *Watermeter) StatsdSink(addr, prefix string) (stop func(), err error) {
+	conn, err := net.Dial("udp", addr)
+	if nil != err {
+		return nil, err
+	}
+
+	var mutex sync.Mutex
+	closed := false
+
+	w.mutex.Lock()
+	last := w.total / w.usageIncrement()
+	w.mutex.Unlock()
+
+	remove := w.observe(func(e UsageEvent) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if closed || e.Gallons <= last {
+			return
+		}
+		n := e.Gallons - last
+		last = e.Gallons
+
+		line := prefix + ".total:" + strconv.FormatUint(n, 10) + "|c\n" +
+			prefix + ".flow:" + strconv.FormatFloat(e.Flow, 'f', -1, 64) + "|g"
+		if _, err := conn.Write([]byte(line)); nil != err {
+			w.reject(err)
+		}
+	})
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			remove()
+
+			mutex.Lock()
+			closed = true
+			conn.Close()
+			mutex.Unlock()
+		})
+	}, nil
+}
@@ -0,0 +1,55 @@
+package watermeter
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestStatsdSink(t *testing.T) {
+	assert := assert.New(t)
+
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if nil != err {
+		t.Skip("UDP unavailable")
+	}
+	defer listener.Close()
+
+	// Deliver in order so the packets can be compared directly.
+	wm := Watermeter{Timeout: time.Hour, SyncCallbacks: true}
+	setNow(&wm, 0)
+	wm.Init(500)
+
+	stop, err := wm.StatsdSink(listener.LocalAddr().String(), "house.main")
+	assert.Nil(err)
+
+	setNow(&wm, 1)
+	wm.Update(1000)
+	setNow(&wm, 2)
+	wm.Update(2000)
+
+	buf := make([]byte, 512)
+	for _, want := range []string{
+		"house.main.total:1|c\nhouse.main.flow:1|g",
+		"house.main.total:2|c\nhouse.main.flow:2|g",
+	} {
+		listener.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := listener.ReadFrom(buf)
+		assert.Nil(err)
+		assert.Equal(want, string(buf[:n]))
+	}
+
+	stop()
+	stop()
+	assert.Equal(0, len(wm.observers))
+
+	setNow(&wm, 3)
+	wm.Update(1000)
+	listener.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	_, _, err = listener.ReadFrom(buf)
+	assert.NotNil(err)
+
+	_, err = wm.StatsdSink("not an address", "x")
+	assert.NotNil(err)
+}
@@ -0,0 +1,63 @@
+package watermeter
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestUpdateTagged(t *testing.T) {
+	assert := assert.New(t)
+
+	wm := Watermeter{Timeout: time.Hour}
+	setNow(&wm, 0)
+	wm.Init(0)
+
+	setNow(&wm, 1)
+	wm.UpdateTagged(1500, "shower")
+	setNow(&wm, 2)
+	wm.UpdateTagged(500, "irrigation")
+	wm.Update(250)
+	setNow(&wm, 3)
+	wm.UpdateTagged(1500, "irrigation")
+
+	assert.Equal(uint64(1500), wm.GetTaggedTotal("shower"))
+	assert.Equal(uint64(2000), wm.GetTaggedTotal("irrigation"))
+	assert.Equal(uint64(0), wm.GetTaggedTotal("sink"))
+	assert.Equal(map[string]uint64{"shower": 1500, "irrigation": 2000}, wm.GetAllTags())
+
+	assert.Equal(uint64(3), wm.GetGallons())
+	assert.Equal(3.75/3, wm.GetFlow(3*time.Minute))
+}
+
+func TestTaggedVolumeRange(t *testing.T) {
+	assert := assert.New(t)
+
+	wm := Watermeter{Timeout: time.Hour}
+	setNow(&wm, 0)
+	wm.Init(0)
+
+	setNow(&wm, 1)
+	wm.UpdateTagged(1500, "shower")
+	setNow(&wm, 2)
+	wm.UpdateTagged(500, "irrigation")
+	wm.Update(250)
+	setNow(&wm, 3)
+	wm.UpdateTagged(1500, "irrigation")
+	setNow(&wm, 4)
+	wm.UpdateTagged(1000, "shower")
+
+	assert.Equal(map[string]uint64{"shower": 2500, "irrigation": 2000, "": 250},
+		wm.TaggedVolumeRange(at(0, 0), at(4, 0)))
+
+	// The updates at minutes 2 and 4 straddle the range and are prorated.
+	split := wm.TaggedVolumeRange(at(1, 30), at(3, 30))
+	assert.Equal(map[string]uint64{"shower": 500, "irrigation": 1750, "": 250}, split)
+	var sum uint64
+	for _, v := range split {
+		sum += v
+	}
+	assert.Equal(wm.GetVolumeRange(at(1, 30), at(3, 30)), sum)
+
+	assert.Equal(map[string]uint64{}, wm.TaggedVolumeRange(at(3, 0), at(1, 0)))
+}
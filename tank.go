@@ -0,0 +1,22 @@
+package watermeter
+
+// EstimateRemaining estimates the whole gallons left in a tank of
+// capacityGallons the meter measures the outflow of, given the running total
+// in 1/1000 gallon units when the tank was last full.  The result is clamped
+// at zero.
+func (w *Watermeter) EstimateRemaining(capacityGallons uint64, filledTotal uint64) uint64 {
+	w.mutex.Lock()
+	total := w.total
+	w.mutex.Unlock()
+
+	var used uint64
+	if filledTotal < total {
+		used = total - filledTotal
+	}
+
+	capacity := capacityGallons * 1000
+	if capacity <= used {
+		return 0
+	}
+	return (capacity - used) / 1000
+}
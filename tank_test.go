@@ -0,0 +1,25 @@
+package watermeter
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestEstimateRemaining(t *testing.T) {
+	assert := assert.New(t)
+
+	wm := Watermeter{Timeout: time.Hour}
+	setNow(&wm, 0)
+	wm.Init(10000)
+
+	setNow(&wm, 1)
+	wm.Update(250500)
+	assert.Equal(uint64(749), wm.EstimateRemaining(1000, 10000))
+	assert.Equal(uint64(1000), wm.EstimateRemaining(1000, 260500))
+	assert.Equal(uint64(1000), wm.EstimateRemaining(1000, 300000))
+
+	// More was used than the tank holds.
+	assert.Equal(uint64(0), wm.EstimateRemaining(200, 10000))
+	assert.Equal(uint64(0), wm.EstimateRemaining(0, 10000))
+}
@@ -0,0 +1,146 @@
+package watermeter
+
+import (
+	"time"
+)
+
+// minTrendDays is the fewest complete days UsageTrend fits a line to.
+const minTrendDays = 3
+
+// UsageTrend fits a least squares line to the gallons used on each complete
+// day retained in the configured Location, up to the last 90.  slopePerDay is
+// the change in daily gallons per day, so a slowly worsening leak shows as a
+// small but persistently positive slope, and r2 is how well the line fits
+// from 0 to 1.  Days without any updates count as 0 gallons.  ok is false
+// until minTrendDays days have completed since Init.
+func (w *Watermeter) UsageTrend() (slopePerDay float64, r2 float64, ok bool) {
+	now := w.now().In(w.location())
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	var ys []float64
+	w.mutex.Lock()
+	if nil != w.profile && 0 < len(w.profile.daily) {
+		for _, b := range w.profile.daily {
+			if b.day.Before(today) {
+				ys = append(ys, float64(b.volume)/1000)
+			}
+		}
+
+		// Idle days since the last update have no bucket yet.
+		next := w.profile.daily[len(w.profile.daily)-1].day.AddDate(0, 0, 1)
+		for ; next.Before(today); next = next.AddDate(0, 0, 1) {
+			ys = append(ys, 0)
+		}
+	}
+	w.mutex.Unlock()
+
+	if extra := len(ys) - retainedDays; 0 < extra {
+		ys = ys[extra:]
+	}
+
+	if len(ys) < minTrendDays {
+		return 0, 0, false
+	}
+
+	n := float64(len(ys))
+	var meanY float64
+	for _, y := range ys {
+		meanY += y
+	}
+	meanY /= n
+	meanX := (n - 1) / 2
+
+	var sxx, sxy, syy float64
+	for i, y := range ys {
+		dx, dy := float64(i)-meanX, y-meanY
+		sxx += dx * dx
+		sxy += dx * dy
+		syy += dy * dy
+	}
+
+	slopePerDay = sxy / sxx
+	if 0 == syy {
+		// A flat series is fit exactly by a flat line.
+		return slopePerDay, 1, true
+	}
+	return slopePerDay, sxy * sxy / (sxx * syy), true
+}
+
+// minProjectionFraction is the fraction of the day that must have passed
+// before RateVsBaseline extrapolates it.
+const minProjectionFraction = 0.25
+
+// RateVsBaseline gets today's projected usage as a multiple of baselineGPD,
+// a target in gallons per day, so 1.2 means 20% over target.  The gallons
+// used so far today in the configured Location are extrapolated over the
+// whole day by the fraction of it that has passed.  A few early morning
+// gallons would extrapolate wildly, so it returns 0 until a quarter of the
+// day has passed, and also if baselineGPD isn't positive.
+func (w *Watermeter) RateVsBaseline(baselineGPD float64) float64 {
+	if false == (0 < baselineGPD) {
+		return 0
+	}
+
+	now := w.now().In(w.location())
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	fraction := float64(now.Sub(today)) / float64(today.AddDate(0, 0, 1).Sub(today))
+	if fraction < minProjectionFraction {
+		return 0
+	}
+
+	var used uint64
+	w.mutex.Lock()
+	if nil != w.profile && 0 < len(w.profile.daily) {
+		if last := w.profile.daily[len(w.profile.daily)-1]; last.day.Equal(today) {
+			used = last.volume
+		}
+	}
+	w.mutex.Unlock()
+
+	return float64(used) / 1000 / fraction / baselineGPD
+}
+
+// PeakUsagePeriod gets the start of the period of the specified granularity
+// with the most usage and the volume in 1/1000 gallon units used during it.
+// A granularity of 24 hours uses the daily buckets, so each period is a
+// calendar day in the configured Location and reaches back 90 days.  Any
+// other granularity scans the retained events, attributing each update to
+// the period its time falls in, with periods aligned as by time.Truncate.
+// The earliest period wins a tie, and the zero values are returned if there
+// was no usage or granularity isn't positive.
+func (w *Watermeter) PeakUsagePeriod(granularity time.Duration) (start time.Time, volume uint64) {
+	if 0 >= granularity {
+		return time.Time{}, 0
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if 24*time.Hour == granularity {
+		if nil != w.profile {
+			for _, b := range w.profile.daily {
+				if volume < b.volume {
+					start, volume = b.day, b.volume
+				}
+			}
+		}
+		return start, volume
+	}
+
+	var period time.Time
+	var sum uint64
+	events := w.entries()
+	for i := 1; i < len(events); i++ {
+		a, b := events[i-1], events[i]
+		if p := b.time.Truncate(granularity); false == p.Equal(period) {
+			period, sum = p, 0
+		}
+		if a.total < b.total {
+			sum += b.total - a.total
+		}
+		if volume < sum {
+			start, volume = period, sum
+		}
+	}
+	return start, volume
+}
@@ -0,0 +1,176 @@
+package watermeter
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestUsageTrend(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := time.Date(2016, time.December, 1, 8, 0, 0, 0, time.UTC)
+	wm := Watermeter{
+		Timeout:  time.Hour,
+		Location: time.UTC,
+		now:      func() time.Time { return clock },
+	}
+	wm.Init(0)
+
+	// Only today so far.
+	_, _, ok := wm.UsageTrend()
+	assert.False(ok)
+
+	// Roughly 2 more gallons a day, with a little noise.
+	used := []uint{50000, 52500, 53500, 56000, 58500, 59500, 62000}
+	for day, m := range used {
+		clock = time.Date(2016, time.December, 1+day, 8, 0, 0, 0, time.UTC)
+		wm.Update(m / 2)
+		clock = clock.Add(10 * time.Hour)
+		wm.Update(m / 2)
+	}
+
+	// The last day isn't complete yet.
+	slope, r2, ok := wm.UsageTrend()
+	assert.True(ok)
+	assert.InDelta(1.94, slope, 0.01)
+	assert.Greater(r2, 0.98)
+
+	clock = time.Date(2016, time.December, 8, 1, 0, 0, 0, time.UTC)
+	slope, r2, ok = wm.UsageTrend()
+	assert.True(ok)
+	assert.InDelta(1.96, slope, 0.01)
+	assert.Greater(r2, 0.98)
+}
+
+func TestUsageTrendSkippedDays(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := time.Date(2016, time.December, 1, 8, 0, 0, 0, time.UTC)
+	wm := Watermeter{
+		Timeout:  time.Hour,
+		Location: time.UTC,
+		now:      func() time.Time { return clock },
+	}
+	wm.Init(0)
+
+	for _, day := range []int{0, 2, 4} {
+		clock = time.Date(2016, time.December, 1+day, 8, 0, 0, 0, time.UTC)
+		wm.Update(10000)
+	}
+	clock = time.Date(2016, time.December, 6, 8, 0, 0, 0, time.UTC)
+
+	// 10, 0, 10, 0, 10 gallons; no trend and a poor fit.
+	slope, r2, ok := wm.UsageTrend()
+	assert.True(ok)
+	assert.InDelta(0.0, slope, 1e-9)
+	assert.InDelta(0.0, r2, 1e-9)
+}
+
+func TestUsageTrendIdleDays(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := time.Date(2016, time.December, 1, 8, 0, 0, 0, time.UTC)
+	wm := Watermeter{
+		Timeout:  time.Hour,
+		Location: time.UTC,
+		now:      func() time.Time { return clock },
+	}
+	wm.Init(0)
+
+	for day := 0; day < 3; day++ {
+		clock = time.Date(2016, time.December, 1+day, 8, 0, 0, 0, time.UTC)
+		wm.Update(10000)
+	}
+
+	// 10, 10, 10 gallons and then two idle days through yesterday.
+	clock = time.Date(2016, time.December, 6, 8, 0, 0, 0, time.UTC)
+	slope, r2, ok := wm.UsageTrend()
+	assert.True(ok)
+	assert.InDelta(-3.0, slope, 1e-9)
+	assert.InDelta(0.75, r2, 1e-9)
+
+	// Only the last 90 days count, which are all idle by now.
+	clock = clock.AddDate(0, 0, 200)
+	slope, r2, ok = wm.UsageTrend()
+	assert.True(ok)
+	assert.InDelta(0.0, slope, 1e-9)
+	assert.InDelta(1.0, r2, 1e-9)
+}
+
+func TestRateVsBaseline(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := time.Date(2016, time.December, 1, 23, 0, 0, 0, time.UTC)
+	wm := Watermeter{
+		Timeout:  time.Hour,
+		Location: time.UTC,
+		now:      func() time.Time { return clock },
+	}
+	wm.Init(0)
+	wm.Update(500000)
+
+	// 90 gallons by 6pm projects to 120 for the day.
+	clock = time.Date(2016, time.December, 2, 7, 0, 0, 0, time.UTC)
+	wm.Update(40000)
+	clock = time.Date(2016, time.December, 2, 12, 0, 0, 0, time.UTC)
+	wm.Update(50000)
+	clock = time.Date(2016, time.December, 2, 18, 0, 0, 0, time.UTC)
+	assert.InDelta(1.2, wm.RateVsBaseline(100), 1e-9)
+	assert.InDelta(0.6, wm.RateVsBaseline(200), 1e-9)
+	assert.Equal(0.0, wm.RateVsBaseline(0))
+
+	// Too early in the day to project.
+	clock = time.Date(2016, time.December, 3, 5, 0, 0, 0, time.UTC)
+	wm.Update(10000)
+	assert.Equal(0.0, wm.RateVsBaseline(100))
+
+	// Nothing used yet today.
+	clock = time.Date(2016, time.December, 4, 12, 0, 0, 0, time.UTC)
+	assert.Equal(0.0, wm.RateVsBaseline(100))
+}
+
+func TestPeakUsagePeriod(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := time.Date(2016, time.December, 1, 6, 0, 0, 0, time.UTC)
+	wm := Watermeter{
+		Timeout:  72 * time.Hour,
+		Location: time.UTC,
+		now:      func() time.Time { return clock },
+	}
+	wm.Init(0)
+
+	start, volume := wm.PeakUsagePeriod(time.Hour)
+	assert.True(start.IsZero())
+	assert.Equal(uint64(0), volume)
+
+	// Gallons used at each hour of three days.
+	usage := []map[int]uint{
+		{7: 40000, 19: 60000},
+		{7: 30000, 8: 45000, 12: 20000, 19: 240000, 20: 5000},
+		{7: 50000, 18: 90000},
+	}
+	for day, hours := range usage {
+		for h := 0; h < 24; h++ {
+			if m, ok := hours[h]; ok {
+				clock = time.Date(2016, time.December, 1+day, h, 30, 0, 0, time.UTC)
+				wm.Update(m / 2)
+				clock = clock.Add(10 * time.Minute)
+				wm.Update(m / 2)
+			}
+		}
+	}
+
+	start, volume = wm.PeakUsagePeriod(24 * time.Hour)
+	assert.Equal(time.Date(2016, time.December, 2, 0, 0, 0, 0, time.UTC), start)
+	assert.Equal(uint64(340000), volume)
+
+	start, volume = wm.PeakUsagePeriod(time.Hour)
+	assert.Equal(time.Date(2016, time.December, 2, 19, 0, 0, 0, time.UTC), start)
+	assert.Equal(uint64(240000), volume)
+
+	start, volume = wm.PeakUsagePeriod(0)
+	assert.True(start.IsZero())
+	assert.Equal(uint64(0), volume)
+}
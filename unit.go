@@ -0,0 +1,58 @@
+package watermeter
+
+import (
+	"fmt"
+	"time"
+)
+
+// A Unit is a unit of volume used to present the readings of a watermeter.
+// Readings are always kept internally in 1/1000 gallon units.
+type Unit int
+
+const (
+	// Gallons presents readings in US gallons.
+	Gallons Unit = iota
+
+	// Liters presents readings in liters.
+	Liters
+)
+
+// litersPerGallon is the number of liters in a US gallon.
+const litersPerGallon = 3.785411784
+
+// perGallon returns the number of units in a gallon.
+func (u Unit) perGallon() float64 {
+	if Liters == u {
+		return litersPerGallon
+	}
+	return 1
+}
+
+// String returns the abbreviation of the unit.
+func (u Unit) String() string {
+	if Liters == u {
+		return "L"
+	}
+	return "gal"
+}
+
+// volumeString formats the volume in 1/1000 gallon units in the unit, such
+// as "123.456 gal".
+func (u Unit) volumeString(mGallons uint64) string {
+	return fmt.Sprintf("%.3f %s", float64(mGallons)/1000*u.perGallon(), u)
+}
+
+// flowSuffix returns the abbreviation of the unit per minute.
+func (u Unit) flowSuffix() string {
+	if Liters == u {
+		return "lpm"
+	}
+	return "gpm"
+}
+
+// FlowString gets the flow rate over the specified duration formatted in
+// the configured Unit, such as "2.45 gpm".
+func (w *Watermeter) FlowString(duration time.Duration) string {
+	flow := w.GetFlow(duration) * w.Unit.perGallon()
+	return fmt.Sprintf("%.2f %s", flow, w.Unit.flowSuffix())
+}
@@ -0,0 +1,27 @@
+package watermeter
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestFlowString(t *testing.T) {
+	assert := assert.New(t)
+
+	wm := Watermeter{Timeout: time.Hour}
+	setNow(&wm, 0)
+	wm.Init(0)
+
+	assert.Equal("0.00 gpm", wm.FlowString(time.Minute))
+
+	setNow(&wm, 1)
+	wm.Update(2450)
+
+	assert.Equal("2.45 gpm", wm.FlowString(time.Minute))
+
+	wm.Unit = Liters
+	assert.Equal("9.27 lpm", wm.FlowString(time.Minute))
+	assert.Equal("L", Liters.String())
+	assert.Equal("gal", Gallons.String())
+}
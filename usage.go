@@ -0,0 +1,22 @@
+package watermeter
+
+import (
+	"time"
+)
+
+// A UsageEvent is passed to UsageEx each time the total crosses a gallon, or
+// UsageIncrement, boundary.
+type UsageEvent struct {
+	// Gallons and Flow are what Usage is called with.
+	Gallons uint64
+	Flow    float64
+
+	// Start is the time of the event that crossed the previous boundary, or
+	// of the event Init seeded, and End is the time of the event that
+	// crossed this one.
+	Start time.Time
+	End   time.Time
+
+	// Interval is End less Start, the time the increment accrued over.
+	Interval time.Duration
+}
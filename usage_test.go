@@ -0,0 +1,42 @@
+package watermeter
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestUsageEx(t *testing.T) {
+	assert := assert.New(t)
+
+	events := make(chan UsageEvent, 4)
+	wm := Watermeter{
+		Timeout: time.Hour,
+		UsageEx: func(e UsageEvent) { events <- e },
+	}
+	setNow(&wm, 0)
+	wm.Init(0)
+
+	setNow(&wm, 1)
+	wm.Update(600)
+	setNow(&wm, 2)
+	wm.Update(600)
+	setNow(&wm, 6)
+	wm.Update(1800)
+
+	want := []UsageEvent{
+		{Gallons: 1, Flow: 1.2 / 2, Start: at(0, 0), End: at(2, 0), Interval: 2 * time.Minute},
+		{Gallons: 3, Flow: 1.8 / 4, Start: at(2, 0), End: at(6, 0), Interval: 4 * time.Minute},
+	}
+	got := []UsageEvent{}
+	for range want {
+		select {
+		case e := <-events:
+			got = append(got, e)
+		case <-time.After(time.Second):
+			assert.Fail("UsageEx was not called")
+			return
+		}
+	}
+	assert.ElementsMatch(want, got)
+}
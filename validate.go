@@ -0,0 +1,50 @@
+package watermeter
+
+import (
+	"fmt"
+)
+
+// Validate checks the internal invariants of the watermeter and returns an
+// error describing the first violation found, or nil.  It is meant as a
+// periodic self-check.  The events must be ordered newest first, the newest
+// must carry the running total, the totals must not decrease unless the
+// meter is Bidirectional, the last gallon boundary must match the running
+// total and the event count must respect MaxEvents and WithMaxMemory.
+func (w *Watermeter) Validate() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if 0 == w.events.Len() {
+		return fmt.Errorf("watermeter: no events; Init was not called")
+	}
+
+	newest := w.events.Front().Value.(*entry)
+	if newest.total != w.total {
+		return fmt.Errorf("watermeter: newest event total %d doesn't match the running total %d", newest.total, w.total)
+	}
+
+	n := 0
+	for item := w.events.Front(); nil != item.Next(); item = item.Next() {
+		n++
+		e, older := item.Value.(*entry), item.Next().Value.(*entry)
+		if e.time.Before(older.time) {
+			return fmt.Errorf("watermeter: event %d at %s is older than the event after it at %s", n, e.time, older.time)
+		}
+		if false == w.Bidirectional && e.total < older.total {
+			return fmt.Errorf("watermeter: event %d total %d is less than the older total %d", n, e.total, older.total)
+		}
+	}
+
+	if w.lastGallon.time.After(newest.time) {
+		return fmt.Errorf("watermeter: last gallon at %s is newer than the newest event at %s", w.lastGallon.time, newest.time)
+	}
+	if increment := w.usageIncrement(); false == w.Bidirectional && w.lastGallon.total/increment != w.total/increment {
+		return fmt.Errorf("watermeter: last gallon total %d isn't on the boundary of the running total %d", w.lastGallon.total, w.total)
+	}
+
+	if max := w.maxEvents(); 0 < max && max < w.events.Len() {
+		return fmt.Errorf("watermeter: %d events retained, more than the limit of %d", w.events.Len(), max)
+	}
+
+	return nil
+}
@@ -0,0 +1,57 @@
+package watermeter
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	build := func() *Watermeter {
+		wm := &Watermeter{Timeout: time.Hour, MaxEvents: 5}
+		setNow(wm, 0)
+		wm.Init(500)
+		for min := 1; min <= 8; min++ {
+			setNow(wm, min)
+			wm.Update(400)
+		}
+		return wm
+	}
+
+	wm := Watermeter{}
+	assert.NotNil(wm.Validate())
+
+	assert.Nil(build().Validate())
+
+	corrupt := []struct {
+		name string
+		fn   func(*Watermeter)
+	}{
+		{"total", func(w *Watermeter) { w.total++ }},
+		{"order", func(w *Watermeter) {
+			e := w.events.Back().Value.(*entry)
+			e.time = e.time.Add(time.Hour)
+		}},
+		{"monotonic", func(w *Watermeter) {
+			w.events.Front().Next().Value.(*entry).total = 1 << 40
+		}},
+		{"future gallon", func(w *Watermeter) { w.lastGallon.time = w.lastGallon.time.Add(time.Hour) }},
+		{"stale gallon", func(w *Watermeter) { w.lastGallon.total -= 2000 }},
+		{"max events", func(w *Watermeter) { w.MaxEvents = 3 }},
+	}
+	for _, c := range corrupt {
+		wm := build()
+		c.fn(wm)
+		assert.NotNil(wm.Validate(), c.name)
+	}
+
+	// A bidirectional meter's total may go down.
+	bi := Watermeter{Timeout: time.Hour, Bidirectional: true}
+	setNow(&bi, 0)
+	bi.Init(5000)
+	setNow(&bi, 1)
+	bi.UpdateSigned(-1500)
+	assert.Nil(bi.Validate())
+}
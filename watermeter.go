@@ -3,37 +3,283 @@ package watermeter
 
 import (
 	"container/list"
+	"context"
+	"errors"
 	"fmt"
+	"log/slog"
+	"math"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// DefaultTimeout is the Timeout used when none is set.
+const DefaultTimeout = time.Hour
+
+// ErrImplausibleUpdate is reported when an update exceeds MaxSingleUpdate.
+var ErrImplausibleUpdate = errors.New("watermeter: implausible update")
+
+// ErrCallbackTimeout is reported when a synchronous callback runs longer than
+// CallbackTimeout.
+var ErrCallbackTimeout = errors.New("watermeter: callback timed out")
+
+// ErrImplausibleInitial is returned by InitE when the initial total exceeds
+// MaxInitial or looks like a wrapped negative number.
+var ErrImplausibleInitial = errors.New("watermeter: implausible initial total")
+
+// ErrReverseFlow is reported when UpdateSigned is given a negative volume and
+// Bidirectional is not set.
+var ErrReverseFlow = errors.New("watermeter: reverse flow on a forward only meter")
+
+// ErrNegativeTotal is reported when a reverse update would take the total
+// below zero.
+var ErrNegativeTotal = errors.New("watermeter: total would go negative")
+
 type entry struct {
-	time  time.Time
-	total uint64
+	time      time.Time
+	total     uint64
+	tag       string
+	heartbeat bool
 }
 
 // A Watermeter represents a watermeter with a simple magnet and sensor set
 // at a specific volume flow rate.
+//
+// All methods are safe for concurrent use once Init returns, except Init
+// itself, which must complete before anything else uses the meter.  An
+// update of a meter that was never initialized initializes it to 0 first.
+// The exported fields are configuration and must not be written after Init;
+// use SetTimeout, SetUsage and SetChange or Reconfigure to change them while
+// the meter is in use.
 type Watermeter struct {
+	// Name, if set, identifies the meter.
+	Name string
+
+	// Timeout is how long events are retained for flow calculations.  It is
+	// set to DefaultTimeout by Init if it is not positive.  The newest event
+	// older than Timeout is also retained as a boundary anchor, so GetFlow
+	// and GetVolume over Timeout start at the total interpolated at its edge.
 	Timeout time.Duration
-	Usage   func(gallons uint64, flow float64)
-	Change  func()
 
-	now        func() time.Time
-	lastGallon entry
-	total      uint64
-	events     list.List
-	mutex      sync.Mutex
+	// MaxEvents is the maximum number of events retained, even if they are
+	// within Timeout.  Zero means no limit.  See also WithMaxMemory.
+	MaxEvents int
+
+	// CoalesceWindow, if positive, merges an update arriving within it of the
+	// newest event into that event instead of storing a new one.  The merged
+	// event keeps its time and takes the new total, so no volume is lost but
+	// the history is no finer than CoalesceWindow.  The event Init seeds is
+	// never merged into, so the flow still has a starting point, and events
+	// with different tags are never merged.
+	CoalesceWindow time.Duration
+
+	// MaxInitial, if set, is the largest initial total in 1/1000 gallon units
+	// InitE accepts.  Init doesn't check it.
+	MaxInitial uint64
+
+	// AlertCooldown is the minimum time between alarms of the same
+	// AlertKind.  Alarms raised during the cooldown are dropped.  Zero
+	// disables it.
+	AlertCooldown time.Duration
+
+	// MaxSingleUpdate is the largest number of 1/1000 gallons a single
+	// update may apply.  Larger updates are treated as glitches, such as two
+	// sources driving the same meter, and rejected.  Zero means no limit.
+	MaxSingleUpdate uint
+
+	// UnitsPerGallon is how many sensor pulses make a gallon, for meters
+	// updated by UpdatePulses.
+	UnitsPerGallon uint64
+
+	// OnError, if set, is called in its own goroutine with each rejected
+	// update or other error.
+	OnError func(err error)
+
+	// Logger, if set, logs updates, prunes and Usage calls at Debug and
+	// alarms and rejections at Warn.
+	Logger *slog.Logger
+
+	// StatsCacheTTL is how long the percentile, median and histogram
+	// computations are reused before they are computed again.  Zero
+	// disables the cache.
+	StatsCacheTTL time.Duration
+
+	// Unit is the unit readings are presented in.  It defaults to Gallons.
+	Unit Unit
+
+	// Location is the time zone used for time of day and calendar day
+	// reporting.  It defaults to the local time zone.
+	Location *time.Location
+
+	// Usage, if set, is called in its own goroutine each time a whole
+	// gallon passes through the meter.  If UsageIncrement is set it is
+	// instead called each time the total crosses a multiple of it and
+	// gallons is the number of increments in the total.  The flow is since
+	// the previous call, or 0 if no time has passed since it, unless
+	// UsageFlowWindow is set.
+	Usage func(gallons uint64, flow float64)
+
+	// UsageFlowWindow, if positive, makes the flow passed to Usage the flow
+	// over this window ending at the update, as GetFlow computes it, rather
+	// than the average since the previous call.  The average since the
+	// previous call smears a gallon that dribbled in over an idle period
+	// into a misleadingly low rate; a short window instead reflects the flow
+	// at the moment the gallon completed.
+	UsageFlowWindow time.Duration
+
+	// UsageEx, if set, is called alongside Usage with the same readings and
+	// the time the increment accrued over.
+	UsageEx func(UsageEvent)
+
+	// UsageIncrement is the number of 1/1000 gallon units between Usage
+	// calls.  It defaults to 1000, a whole gallon.
+	UsageIncrement uint64
+
+	// MaxReportedFlow, if positive, caps the flow rate (gallons/min) passed
+	// to Usage and returned by InstantFlow, so a double fired sensor doesn't
+	// report an enormous rate.  Only the reported rate is capped; the totals
+	// are unaffected.
+	MaxReportedFlow float64
+
+	// LowFlow, if positive, is the flow rate (gallons/min) below which a
+	// flow is reported by FlowState as a trickle.
+	LowFlow float64
+
+	// HighFlow, if positive, is the flow rate (gallons/min) from which a
+	// flow is reported by FlowState as high.
+	HighFlow float64
+
+	// FlowStateWindow is the window FlowState measures the flow over, and
+	// compares with the window before it.  It defaults to a minute.
+	FlowStateWindow time.Duration
+
+	// SuppressSeedFlow reports a flow of 0 to the first Usage call after
+	// Init rather than a flow measured from the event Init seeds, which may
+	// span an arbitrary startup period.
+	SuppressSeedFlow bool
+
+	// Bidirectional puts the meter in a distinct mode for sensors that report
+	// the flow direction.  UpdateSigned accepts negative volumes, the total
+	// is the net volume, and GetFlow is negative while the flow is reversed.
+	// Usage only fires as the net total crosses upward, so re-crossing a
+	// boundary after a reversal fires it again.  The total may not go below
+	// zero; seed Init with an offset if the net can.
+	Bidirectional bool
+
+	// Change, if set, is called in its own goroutine after every update.
+	// Leave it nil on high rate meters; no goroutine is started when it is
+	// nil.
+	Change func()
+
+	// Pool, if set, runs the callbacks instead of a new goroutine per call.
+	Pool *Pool
+
+	// SyncCallbacks runs the callbacks in the goroutine of the call that
+	// raised them, before it returns, instead of on the Pool or a new
+	// goroutine.  The mutex isn't held, so a callback may use the meter,
+	// but an Idle callback runs on the meter's own goroutine and must not
+	// call Close, which waits for that goroutine to exit.
+	SyncCallbacks bool
+
+	// CallbackTimeout, if positive, bounds how long a call waits for a
+	// synchronous callback.  Each callback then runs in its own goroutine
+	// and one still running after CallbackTimeout is abandoned and reported
+	// to OnError as ErrCallbackTimeout.  An abandoned callback keeps running
+	// and one that never returns leaks its goroutine.
+	CallbackTimeout time.Duration
+
+	// Scheduler, if set, runs the idle checks and automatic persistence
+	// instead of goroutines of the meter's own.
+	Scheduler *Scheduler
+
+	// Lite keeps only the running total and the newest event, updating it in
+	// place, for gateways with thousands of meters.  The totals, Usage with
+	// its flow, the alarms and persistence work as usual, but there is no
+	// history, so GetFlow and the other history queries read 0.
+	Lite bool
+
+	// StuckTimeout is how long updates may keep arriving without the total
+	// changing before the meter is considered stuck.  Zero disables it.
+	StuckTimeout time.Duration
+
+	// Stuck, if set, is called once when the meter becomes stuck with the
+	// time the total last changed.
+	Stuck func(since time.Time)
+
+	// IdleTimeout is how long the meter may go without any update before it
+	// is considered idle.  Zero disables it.
+	IdleTimeout time.Duration
+
+	// IdleCheckInterval is how often the meter checks if it is idle.  It
+	// defaults to a quarter of IdleTimeout if it is not positive, and is at
+	// least a millisecond.
+	IdleCheckInterval time.Duration
+
+	// Idle, if set, is called once when the meter becomes idle with the time
+	// of the last update.
+	Idle func(since time.Time)
+
+	// HeartbeatInterval, if positive, inserts a heartbeat event at the
+	// current time and total whenever no event has been added for an
+	// interval, so a sink sees no gaps and the flow reads a clean 0 rather
+	// than averaging across the gap once the flow resumes.  Heartbeats don't
+	// change the total and aren't added in Lite mode.
+	HeartbeatInterval time.Duration
+
+	now             func() time.Time
+	lastGallon      entry
+	seedGallon      bool
+	lastChange      time.Time
+	lastUpdate      time.Time
+	firstFlow       time.Time
+	stuck           bool
+	idle            bool
+	total           uint64
+	lifetime        uint64
+	evictions       uint64
+	evictedVolume   uint64
+	tags            map[string]uint64
+	counterSeeded   bool
+	counterReading  uint64
+	pulses          uint64
+	events          list.List
+	evicted         *entry
+	stats           map[time.Duration]statsCache
+	profile         *hourlyProfile
+	alerts          map[AlertKind]time.Time
+	alertCh         chan Alert
+	observers       []*observer
+	persistPath     string
+	persistInterval time.Duration
+	maxMemory       int
+	expectedRate    float64
+	free            []*entry
+	statsComputed   int
+	mutex           sync.Mutex
+	stop            chan struct{}
+	ctx             context.Context
+	unwatch         func() bool
+	wg              sync.WaitGroup
+	initialized     atomic.Bool
+	lazyInit        sync.Once
 }
 
 func (e *entry) String() string {
 	return fmt.Sprintf("time: %s, total: %d", e.time, e.total)
 }
 
-// String returns the formatted string representation of the object.
+// String returns the formatted string representation of the object.  The
+// running total and last gallon are shown in the configured Unit; the events
+// are left in 1/1000 gallon units to keep them compact.
 func (w *Watermeter) String() string {
-	rv := fmt.Sprintf("{\n\tTimeout: %s,\n\tUsage: %p,\n\tChange: %p,\n\tnow: %p,\n\tlastGallon{ %s },\n\ttotal: %d,\n\tevents { ", w.Timeout, w.Usage, w.Change, w.now, w.lastGallon.String(), w.total)
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	rv := "{\n"
+	if "" != w.Name {
+		rv += fmt.Sprintf("\tName: %s,\n", w.Name)
+	}
+	rv += fmt.Sprintf("\tTimeout: %s,\n\tUsage: %p,\n\tChange: %p,\n\tnow: %p,\n\tlastGallon{ time: %s, total: %s },\n\ttotal: %s,\n\tevents { ", w.Timeout, w.Usage, w.Change, w.now, w.lastGallon.time, w.Unit.volumeString(w.lastGallon.total), w.Unit.volumeString(w.total))
 	e := w.events.Front()
 	comma := ""
 	for nil != e {
@@ -49,99 +295,579 @@ func (w *Watermeter) String() string {
 // Init initializes the watermeter object to the initial state.
 // Argument initial is the initial running total in 1/1000 gallon units.
 func (w *Watermeter) Init(initial uint64) *Watermeter {
-
 	if nil == w.now {
 		w.now = func() time.Time { return time.Now() }
 	}
 
-	w.total = initial
+	w.initAt(initial, w.now())
+
+	return w
+}
+
+// InitE initializes the watermeter like Init after checking the initial
+// total, so a total computed by a signed subtraction that wrapped is caught
+// before it corrupts every later reading.  An initial total above MaxInitial,
+// or above math.MaxInt64 whether or not MaxInitial is set, is rejected with
+// ErrImplausibleInitial and the watermeter is left as it was.
+func (w *Watermeter) InitE(initial uint64) (*Watermeter, error) {
+	if math.MaxInt64 < initial || (0 < w.MaxInitial && w.MaxInitial < initial) {
+		return nil, ErrImplausibleInitial
+	}
+	return w.Init(initial), nil
+}
+
+// ensureInit calls Init(0) once if the watermeter was never initialized.
+func (w *Watermeter) ensureInit() {
+	if w.initialized.Load() {
+		return
+	}
+	w.lazyInit.Do(func() {
+		if false == w.initialized.Load() {
+			w.Init(0)
+		}
+	})
+}
+
+// initAt initializes the watermeter object to the initial running total at
+// the specified time.
+func (w *Watermeter) initAt(initial uint64, t time.Time) {
+	w.Close()
+
+	if 0 >= w.Timeout {
+		w.Timeout = DefaultTimeout
+	}
+
 	w.mutex = sync.Mutex{}
+	w.reset(initial, t)
+
+	w.initialized.Store(true)
+	w.stop = make(chan struct{})
+	if nil != w.ctx {
+		w.unwatch = context.AfterFunc(w.ctx, func() { w.Close() })
+	}
+	if nil != w.Scheduler {
+		if 0 < w.IdleTimeout || 0 < w.HeartbeatInterval || ("" != w.persistPath && 0 < w.persistInterval) {
+			w.Scheduler.add(w)
+		}
+		return
+	}
+	if 0 < w.IdleTimeout {
+		w.background(w.watchIdle)
+	}
+	if 0 < w.HeartbeatInterval {
+		w.background(w.watchHeartbeat)
+	}
+	if "" != w.persistPath && 0 < w.persistInterval {
+		w.background(w.autoPersist)
+	}
+}
+
+// reset clears the running total and history, seeding the history with a
+// single event at the initial total and time t.  The lifetime total is kept.
+// The caller must hold the mutex.
+func (w *Watermeter) reset(initial uint64, t time.Time) {
+	w.total = initial
+	w.tags = nil
+	w.counterSeeded = false
+	w.pulses = 0
+	w.evicted = nil
+	w.stats = nil
+	w.profile = nil
+	w.alerts = nil
 	w.events.Init()
+	w.preallocate()
 
-	e := new(entry)
-	e.time = w.now()
+	e := w.newEntry()
+	e.time = t
 	e.total = w.total
 	w.events.PushFront(e)
 	w.lastGallon = *e
+	w.seedGallon = true
+	w.lastChange = e.time
+	w.lastUpdate = e.time
+	w.stuck = false
+	w.idle = false
+	w.firstFlow = time.Time{}
+}
 
-	return w
+// Reset clears the running total and history of the watermeter while it is
+// in use, as if it were initialized to 0 now.  The configuration, callbacks
+// and lifetime total are kept.
+func (w *Watermeter) Reset() {
+	now := w.now()
+
+	w.mutex.Lock()
+	w.reset(0, now)
+	w.mutex.Unlock()
+}
+
+// GetLifetimeGallons gets the whole gallons that have passed through the
+// meter since it was first initialized, like the tamper evident register of
+// a physical meter.  Unlike the running total it is never cleared by Init or
+// Reset, and only forward flow is counted.  It is carried by MarshalCompact.
+func (w *Watermeter) GetLifetimeGallons() uint64 {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.lifetime / 1000
 }
 
-// GetFlow gets the flow rate (gallons/min) over the specified duration.
+// Close stops the goroutines started by the watermeter and waits for them to
+// exit.  The Alerts channel, if any, is closed.  Close deadlocks if it is
+// called from one of those goroutines, such as a StartFlowPoller fn or an
+// Idle callback with SyncCallbacks; call it from a new goroutine instead.
+func (w *Watermeter) Close() error {
+	w.mutex.Lock()
+	stop := w.stop
+	w.stop = nil
+	unwatch := w.unwatch
+	w.unwatch = nil
+	if nil != w.alertCh {
+		close(w.alertCh)
+		w.alertCh = nil
+	}
+	w.mutex.Unlock()
+
+	if nil != unwatch {
+		unwatch()
+	}
+	if nil != stop {
+		close(stop)
+		w.wg.Wait()
+	}
+	if nil != w.Scheduler && w.Scheduler.remove(w) && "" != w.persistPath && 0 < w.persistInterval {
+		w.persist()
+	}
+	return nil
+}
+
+// background runs fn in a goroutine that is stopped by Close.  It returns
+// false without running fn if the watermeter isn't running.
+func (w *Watermeter) background(fn func(stop <-chan struct{})) bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	stop := w.stop
+	if nil == stop {
+		return false
+	}
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		fn(stop)
+	}()
+	return true
+}
+
+// GetFlow gets the flow rate (gallons/min) over the specified duration.  It
+// returns 0 for a duration that isn't positive and before any update.  On a
+// Bidirectional meter the rate is negative while the net flow is reversed.
 func (w *Watermeter) GetFlow(duration time.Duration) float64 {
 	now := w.now()
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.flow(now, duration)
+}
+
+// flow gets the flow rate (gallons/min) over the duration ending at now.  The
+// caller must hold the mutex.
+func (w *Watermeter) flow(now time.Time, duration time.Duration) float64 {
+	flow, _, _ := w.flowSpan(now, duration)
+	return flow
+}
+
+// flowSpan gets the flow rate (gallons/min) over the duration ending at now,
+// the time from the oldest event in the duration to now and the number of
+// events in the duration.  The caller must hold the mutex.
+func (w *Watermeter) flowSpan(now time.Time, duration time.Duration) (float64, time.Duration, int) {
 	then := now.Add(-duration)
 
 	end := entry{time: now, total: w.total}
-	start := entry{time: now, total: w.total}
+	start, samples := w.windowStart(now, then)
 
-	w.mutex.Lock()
+	return rate(end.total-start.total, duration), end.time.Sub(start.time), samples
+}
 
-	item := w.events.Front()
+// windowStart gets the event a window from then to now is measured from and
+// the number of events in the window.  When then falls between two retained
+// events, such as the boundary anchor and the event after it, the window
+// starts at the total interpolated between them.  When it is older than
+// every retained event it is interpolated from the anchor MaxEvents evicted,
+// if there is one, and otherwise starts at the oldest event.  The caller must
+// hold the mutex.
+func (w *Watermeter) windowStart(now, then time.Time) (entry, int) {
+	start := entry{time: now, total: w.total}
+	samples := 0
 
-	for nil != item {
+	var newer *entry
+	item := w.events.Front()
+	for ; nil != item; item = item.Next() {
 		e := item.Value.(*entry)
-		if then.Equal(e.time) || then.Before(e.time) {
-			start.time = e.time
-			start.total = e.total
-			item = item.Next()
-		} else {
-			item = nil
+		if e.time.Before(then) {
+			break
 		}
+		start = *e
+		newer = e
+		samples++
 	}
-	w.mutex.Unlock()
 
-	volumeDelta := end.total - start.total
-	return float64(volumeDelta) / 1000 / duration.Minutes()
+	if nil != item && nil != newer {
+		start = entry{time: then, total: interpolate([]entry{*item.Value.(*entry), *newer}, then)}
+	} else if oldest := w.events.Back().Value.(*entry); nil == item && nil != w.evicted {
+		start = entry{time: then, total: interpolate([]entry{*w.evicted, *oldest}, then)}
+	}
+	return start, samples
 }
 
 // GetGallons gets the gallon running count.
 func (w *Watermeter) GetGallons() uint64 {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
 	return w.total / 1000
 }
 
+// GetGallonsQuantized gets the running total in 1/1000 gallon units rounded
+// to the nearest multiple of resolution, with halves rounded up, for a
+// display or billing system coarser than the meter.  The stored total keeps
+// its precision.  It returns 0 if resolution is 0.
+func (w *Watermeter) GetGallonsQuantized(resolution uint64) uint64 {
+	if 0 == resolution {
+		return 0
+	}
+
+	w.mutex.Lock()
+	total := w.total
+	w.mutex.Unlock()
+
+	rv := total / resolution * resolution
+	if resolution-total%resolution <= total%resolution {
+		rv += resolution
+	}
+	return rv
+}
+
+// SetTimeout changes Timeout while the meter is in use.  Events already
+// retained are pruned against the new Timeout by the next update.
+func (w *Watermeter) SetTimeout(timeout time.Duration) {
+	if 0 >= timeout {
+		timeout = DefaultTimeout
+	}
+	w.mutex.Lock()
+	w.Timeout = timeout
+	w.mutex.Unlock()
+}
+
+// SetUsage changes the Usage callback while the meter is in use.  An update
+// already in progress may still call the previous callback.
+func (w *Watermeter) SetUsage(fn func(gallons uint64, flow float64)) {
+	w.mutex.Lock()
+	w.Usage = fn
+	w.mutex.Unlock()
+}
+
+// SetChange changes the Change callback while the meter is in use.  An
+// update already in progress may still call the previous callback.
+func (w *Watermeter) SetChange(fn func()) {
+	w.mutex.Lock()
+	w.Change = fn
+	w.mutex.Unlock()
+}
+
 // Update updates the watermeter with the specified number of 1/1000 gallons
-// that have passed through the meter.
+// that have passed through the meter.  Like every update, on a meter that
+// was never initialized it first calls Init(0).
+//
+// An update larger than MaxSingleUpdate is rejected and reported to OnError.
 func (w *Watermeter) Update(mGallons uint) {
-	now := w.now()
-	prune := now.Add(-w.Timeout)
+	w.ensureInit()
+	w.UpdateAt(w.now(), mGallons)
+}
 
-	w.mutex.Lock()
-	before := w.total / 1000
-	w.total += uint64(mGallons)
-	after := w.total / 1000
+// UpdateN updates the watermeter with count pulses of the specified number of
+// 1/1000 gallons each as a single event.  MaxSingleUpdate applies to each
+// pulse, so this is how a legitimate batch is caught up.
+func (w *Watermeter) UpdateN(mGallons uint, count uint) {
+	w.ensureInit()
+	if w.implausible(mGallons) {
+		return
+	}
+	w.update(w.now(), int64(uint64(mGallons)*uint64(count)), "")
+}
 
-	e := new(entry)
-	e.time = now
-	e.total = w.total
-	w.events.PushFront(e)
+// UpdateAt updates the watermeter with the specified number of 1/1000 gallons
+// that passed through the meter at the specified time.  A time earlier than
+// the newest event is treated as the time of the newest event.
+func (w *Watermeter) UpdateAt(now time.Time, mGallons uint) {
+	w.ensureInit()
+	if w.implausible(mGallons) {
+		return
+	}
+	w.update(now, int64(mGallons), "")
+}
+
+// UpdateSigned updates a Bidirectional watermeter with the specified number of
+// 1/1000 gallons, where a negative number is flow in reverse.  A negative
+// update of a meter without Bidirectional set is rejected with
+// ErrReverseFlow.  MaxSingleUpdate applies to the magnitude.
+func (w *Watermeter) UpdateSigned(mGallons int) {
+	w.ensureInit()
+	magnitude := uint(mGallons)
+	if 0 > mGallons {
+		if false == w.Bidirectional {
+			w.reject(ErrReverseFlow)
+			return
+		}
+		magnitude = uint(-mGallons)
+	}
+	if w.implausible(magnitude) {
+		return
+	}
+	w.update(w.now(), int64(mGallons), "")
+}
+
+// implausible reports and returns true if a single update of mGallons
+// exceeds MaxSingleUpdate.
+func (w *Watermeter) implausible(mGallons uint) bool {
+	if 0 < w.MaxSingleUpdate && w.MaxSingleUpdate < mGallons {
+		w.reject(ErrImplausibleUpdate)
+		return true
+	}
+	return false
+}
+
+// reject reports err to OnError if it is set.
+func (w *Watermeter) reject(err error) {
+	if w.logs(slog.LevelWarn) {
+		w.mutex.Lock()
+		total := w.total
+		w.mutex.Unlock()
+		w.log(slog.LevelWarn, "rejected", total, slog.String("error", err.Error()))
+	}
 
+	if fn := w.OnError; nil != fn {
+		// A slow OnError isn't reported to itself.
+		w.deliver(func() { fn(err) }, false)
+	}
+}
+
+// pruneEvents drops the events older than prune, but for the boundary
+// anchor, and evicts those past the MaxEvents limit, returning how many were
+// removed.  At least 2 events are kept.  The caller must hold the mutex.
+func (w *Watermeter) pruneEvents(prune time.Time) int {
+	if nil != w.evicted && w.evicted.time.Before(prune) {
+		w.recycle(w.evicted)
+		w.evicted = nil
+	}
+
+	pruned := 0
 	done := false
-	for false == done {
+	for false == done && 2 < w.events.Len() {
 		item := w.events.Back()
 		e := item.Value.(*entry)
-		if e.time.Before(prune) {
+		if e.time.Before(prune) && nil != item.Prev() && false == item.Prev().Value.(*entry).time.After(prune) {
+			w.events.Remove(item)
+			w.countEviction(e)
+			w.recycle(e)
+			pruned++
+		} else if max := w.maxEvents(); 0 < max && max < w.events.Len() {
 			w.events.Remove(item)
+			w.countEviction(e)
+			pruned++
+			if nil == w.evicted {
+				w.evicted = e
+			} else {
+				w.recycle(e)
+			}
 		} else {
 			done = true
 		}
-		if 3 > w.events.Len() {
-			done = true
+	}
+	return pruned
+}
+
+// coalesces returns true if an update with tag at now is folded into the
+// newest event rather than added as one of its own.  A heartbeat is never
+// folded into.  The caller must hold the mutex.
+func (w *Watermeter) coalesces(now time.Time, tag string) bool {
+	e := w.events.Front().Value.(*entry)
+	return 0 < w.CoalesceWindow && 1 < w.events.Len() && false == e.heartbeat && tag == e.tag && now.Sub(e.time) < w.CoalesceWindow
+}
+
+// update applies the signed volume at the specified time, attributing it to
+// tag unless tag is empty.
+func (w *Watermeter) update(now time.Time, delta int64, tag string) {
+	w.mutex.Lock()
+	if nil != w.ctx && nil != w.ctx.Err() {
+		w.mutex.Unlock()
+		return
+	}
+	if 0 > delta && w.total < uint64(-delta) {
+		w.mutex.Unlock()
+		w.reject(ErrNegativeTotal)
+		return
+	}
+	if newest := w.events.Front().Value.(*entry); now.Before(newest.time) {
+		now = newest.time
+	}
+	prune := now.Add(-w.Timeout)
+
+	increment := w.usageIncrement()
+	before := w.total / increment
+	w.total += uint64(delta)
+	after := w.total / increment
+	crossed := after > before
+
+	if 0 < delta {
+		w.lifetime += uint64(delta)
+		w.addProfile(now, uint64(delta))
+	}
+
+	if "" != tag {
+		if nil == w.tags {
+			w.tags = make(map[string]uint64)
 		}
+		w.tags[tag] += uint64(delta)
 	}
 
-	w.mutex.Unlock()
+	e := w.events.Front().Value.(*entry)
+	if w.Lite {
+		e.time, e.total, e.tag = now, w.total, tag
+	} else if w.coalesces(now, tag) {
+		e.total = w.total
+	} else {
+		e = w.newEntry()
+		e.time = now
+		e.total = w.total
+		e.tag = tag
+		w.events.PushFront(e)
+	}
 
-	if nil != w.Change {
-		go (w.Change)()
+	w.lastUpdate = now
+	w.idle = false
+
+	stuck := false
+	if 0 != delta {
+		if w.firstFlow.IsZero() {
+			w.firstFlow = now
+		}
+		w.lastChange = now
+		w.stuck = false
+	} else if 0 < w.StuckTimeout && false == w.stuck && w.StuckTimeout <= now.Sub(w.lastChange) {
+		w.stuck = true
+		stuck = w.raise(AlertStuck, now, w.lastChange)
 	}
+	since := w.lastChange
 
-	if (after - before) > 0 {
-		if nil != w.Usage {
-			flow := float64(e.total-w.lastGallon.total) / 1000
-			flow /= e.time.Sub(w.lastGallon.time).Minutes()
-			go (w.Usage)(after, flow)
+	var flow float64
+	var accrued UsageEvent
+	if crossed {
+		accrued.Start, accrued.End = w.lastGallon.time, e.time
+		if 0 < w.UsageFlowWindow {
+			flow = w.flow(now, w.UsageFlowWindow)
+		} else if false == w.SuppressSeedFlow || false == w.seedGallon {
+			flow = rate(e.total-w.lastGallon.total, e.time.Sub(w.lastGallon.time))
 		}
 		w.lastGallon = *e
+		w.seedGallon = false
+	}
+
+	pruned := w.pruneEvents(prune)
+
+	total := w.total
+	change, usage, usageEx, stuckFn := w.Change, w.Usage, w.UsageEx, w.Stuck
+	observers := w.observers
+	w.mutex.Unlock()
+
+	if w.logs(slog.LevelDebug) {
+		w.log(slog.LevelDebug, "update", total, slog.Int64("volume", delta))
+		if 0 < pruned {
+			w.log(slog.LevelDebug, "prune", total, slog.Int("events", pruned))
+		}
+		if crossed {
+			w.log(slog.LevelDebug, "usage", total, slog.Uint64("gallons", after), slog.Float64("flow", flow))
+		}
+	}
+	if stuck && w.logs(slog.LevelWarn) {
+		w.log(slog.LevelWarn, "stuck", total, slog.Time("since", since))
+	}
+
+	if nil != change {
+		w.dispatch(change)
+	}
+
+	if stuck && nil != stuckFn {
+		w.dispatch(func() { stuckFn(since) })
+	}
+
+	if crossed && (nil != usage || nil != usageEx || 0 < len(observers)) {
+		flow = w.reportedFlow(flow)
+		if nil != usage {
+			w.dispatch(func() { usage(after, flow) })
+		}
+		accrued.Gallons, accrued.Flow = after, flow
+		accrued.Interval = accrued.End.Sub(accrued.Start)
+		if nil != usageEx {
+			w.dispatch(func() { usageEx(accrued) })
+		}
+		for _, o := range observers {
+			fn := o.fn
+			w.dispatch(func() { fn(accrued) })
+		}
+	}
+}
+
+// usageIncrement returns the number of 1/1000 gallon units between Usage
+// calls.
+func (w *Watermeter) usageIncrement() uint64 {
+	if 0 == w.UsageIncrement {
+		return 1000
+	}
+	return w.UsageIncrement
+}
+
+// dispatch runs the callback fn synchronously if SyncCallbacks is set, on the
+// Pool if one is set, otherwise in its own goroutine.
+func (w *Watermeter) dispatch(fn func()) {
+	w.deliver(fn, true)
+}
+
+// deliver runs the callback fn as dispatch does, reporting a synchronous
+// callback that exceeds CallbackTimeout if report is true.
+func (w *Watermeter) deliver(fn func(), report bool) {
+	switch {
+	case w.SyncCallbacks:
+		w.call(fn, report)
+	case nil != w.Pool:
+		w.Pool.Submit(fn)
+	default:
+		go fn()
+	}
+}
+
+// call runs fn and waits for it to return or for CallbackTimeout.
+func (w *Watermeter) call(fn func(), report bool) {
+	if 0 >= w.CallbackTimeout {
+		fn()
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn()
+	}()
+
+	timer := time.NewTimer(w.CallbackTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+	case <-timer.C:
+		if report {
+			w.reject(ErrCallbackTimeout)
+		}
 	}
 }
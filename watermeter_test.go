@@ -2,6 +2,7 @@ package watermeter
 
 import (
 	"github.com/stretchr/testify/assert"
+	"runtime"
 	"sync"
 	"testing"
 	"time"
@@ -47,7 +48,18 @@ func TestWatermeterString(t *testing.T) {
 	wm.Init(0)
 
 	wm.now = nil
-	assert.Equal("{\n\tTimeout: 4s,\n\tUsage: 0x0,\n\tChange: 0x0,\n\tnow: 0x0,\n\tlastGallon{ time: 2016-12-25 01:00:00 +0000 UTC, total: 0 },\n\ttotal: 0,\n\tevents { \n\t\t{ time: 2016-12-25 01:00:00 +0000 UTC, total: 0 }\n\t}\n}", wm.String())
+	assert.Equal("{\n\tTimeout: 4s,\n\tUsage: 0x0,\n\tChange: 0x0,\n\tnow: 0x0,\n\tlastGallon{ time: 2016-12-25 01:00:00 +0000 UTC, total: 0.000 gal },\n\ttotal: 0.000 gal,\n\tevents { \n\t\t{ time: 2016-12-25 01:00:00 +0000 UTC, total: 0 }\n\t}\n}", wm.String())
+}
+
+func TestWatermeterStringUnit(t *testing.T) {
+	assert := assert.New(t)
+
+	wm := Watermeter{Name: "kitchen", Timeout: time.Minute, Unit: Liters}
+	setNow(&wm, 0)
+	wm.Init(2000)
+
+	wm.now = nil
+	assert.Equal("{\n\tName: kitchen,\n\tTimeout: 1m0s,\n\tUsage: 0x0,\n\tChange: 0x0,\n\tnow: 0x0,\n\tlastGallon{ time: 2016-12-25 01:00:00 +0000 UTC, total: 7.571 L },\n\ttotal: 7.571 L,\n\tevents { \n\t\t{ time: 2016-12-25 01:00:00 +0000 UTC, total: 2000 }\n\t}\n}", wm.String())
 }
 
 func TestWatermeterDeep(t *testing.T) {
@@ -82,8 +94,9 @@ func TestWatermeterDeep(t *testing.T) {
 	setUsage(assert, &wm, &wg, 2, 0.25)
 	wm.Update(250)
 
+	// The tail of the 0.25 gpm interval, less the interpolation's rounding.
 	duration, _ := time.ParseDuration("2s")
-	assert.Equal(0.0, wm.GetFlow(duration))
+	assert.InDelta(0.27, wm.GetFlow(duration), 1e-9)
 
 	duration, _ = time.ParseDuration("2m")
 	assert.Equal(0.250, wm.GetFlow(duration))
@@ -95,3 +108,554 @@ func TestWatermeterDeep(t *testing.T) {
 
 	wg.Wait()
 }
+
+func TestWatermeterNoChangeGoroutine(t *testing.T) {
+	assert := assert.New(t)
+
+	wm := Watermeter{Timeout: time.Minute}
+	setNow(&wm, 0)
+	wm.Init(0)
+
+	before := runtime.NumGoroutine()
+	for i := 0; i < 1000; i++ {
+		wm.Update(1)
+		assert.Equal(before, runtime.NumGoroutine())
+	}
+	assert.Equal(uint64(1), wm.GetGallons())
+}
+
+func BenchmarkUpdateNoCallbacks(b *testing.B) {
+	wm := Watermeter{Timeout: time.Minute}
+	setNow(&wm, 0)
+	wm.Init(0)
+
+	before := runtime.NumGoroutine()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		wm.Update(1)
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(runtime.NumGoroutine()-before)/float64(b.N), "goroutines/op")
+}
+
+func TestWatermeterStuck(t *testing.T) {
+	assert := assert.New(t)
+
+	stuck := make(chan time.Time, 2)
+	wm := Watermeter{
+		Timeout:      time.Hour,
+		StuckTimeout: 3 * time.Minute,
+		Stuck:        func(since time.Time) { stuck <- since },
+	}
+	setNow(&wm, 0)
+	wm.Init(0)
+
+	setNow(&wm, 1)
+	wm.Update(250)
+
+	// Heartbeats without volume until the stuck duration passes.
+	for min := 2; min <= 6; min++ {
+		setNow(&wm, min)
+		wm.Update(0)
+	}
+
+	select {
+	case since := <-stuck:
+		assert.Equal(time.Date(2016, time.December, 25, 1, 1, 0, 0, time.UTC), since)
+	case <-time.After(time.Second):
+		assert.Fail("Stuck was not called")
+	}
+
+	// Fires once per episode, and again after the total moves.
+	setNow(&wm, 7)
+	wm.Update(250)
+	setNow(&wm, 9)
+	wm.Update(0)
+	setNow(&wm, 10)
+	wm.Update(0)
+
+	select {
+	case since := <-stuck:
+		assert.Equal(time.Date(2016, time.December, 25, 1, 7, 0, 0, time.UTC), since)
+	case <-time.After(time.Second):
+		assert.Fail("Stuck was not called again")
+	}
+	assert.Equal(0, len(stuck))
+}
+
+func TestWatermeterUsageIncrement(t *testing.T) {
+	assert := assert.New(t)
+
+	calls := make(chan uint64, 10)
+	wm := Watermeter{
+		Timeout:        time.Hour,
+		UsageIncrement: 100,
+		Usage: func(gallons uint64, flow float64) {
+			calls <- gallons
+		},
+	}
+	setNow(&wm, 0)
+	wm.Init(50)
+
+	setNow(&wm, 1)
+	wm.Update(40) // 90
+	setNow(&wm, 2)
+	wm.Update(20) // 110
+	setNow(&wm, 3)
+	wm.Update(90) // 200
+	setNow(&wm, 4)
+	wm.Update(99) // 299
+	setNow(&wm, 5)
+	wm.Update(250) // 549
+
+	got := []uint64{}
+	for i := 0; i < 3; i++ {
+		select {
+		case n := <-calls:
+			got = append(got, n)
+		case <-time.After(time.Second):
+			assert.Fail("Usage was not called")
+		}
+	}
+	assert.ElementsMatch([]uint64{1, 2, 5}, got)
+}
+
+func TestWatermeterMaxSingleUpdate(t *testing.T) {
+	assert := assert.New(t)
+
+	errs := make(chan error, 2)
+	wm := Watermeter{
+		Timeout:         time.Hour,
+		MaxSingleUpdate: 500,
+		OnError:         func(err error) { errs <- err },
+	}
+	setNow(&wm, 0)
+	wm.Init(0)
+
+	setNow(&wm, 1)
+	wm.Update(500)
+	wm.Update(50000)
+	assert.Equal(uint64(500), wm.TotalAt(at(1, 0)))
+	assert.Equal(2, wm.events.Len())
+
+	select {
+	case err := <-errs:
+		assert.Equal(ErrImplausibleUpdate, err)
+	case <-time.After(time.Second):
+		assert.Fail("OnError was not called")
+	}
+
+	// A batch of plausible pulses is accepted.
+	setNow(&wm, 2)
+	wm.UpdateN(250, 10)
+	assert.Equal(uint64(3), wm.GetGallons())
+
+	wm.UpdateN(501, 1)
+	assert.Equal(uint64(3), wm.GetGallons())
+	select {
+	case err := <-errs:
+		assert.Equal(ErrImplausibleUpdate, err)
+	case <-time.After(time.Second):
+		assert.Fail("OnError was not called")
+	}
+}
+
+func TestWatermeterSuppressSeedFlow(t *testing.T) {
+	var wg sync.WaitGroup
+
+	assert := assert.New(t)
+
+	wm := Watermeter{Timeout: time.Hour, SuppressSeedFlow: true}
+	setNow(&wm, 0)
+	wm.Init(0)
+
+	// A long startup period would report a tiny flow from the seed.
+	setNow(&wm, 50)
+	setUsage(assert, &wm, &wg, 1, 0.0)
+	wm.Update(1000)
+	wg.Wait()
+
+	setNow(&wm, 52)
+	setUsage(assert, &wm, &wg, 2, 0.5)
+	wm.Update(1000)
+	wg.Wait()
+
+	// Without suppression the seed interval is used.
+	wm = Watermeter{Timeout: time.Hour}
+	setNow(&wm, 0)
+	wm.Init(0)
+	setNow(&wm, 50)
+	setUsage(assert, &wm, &wg, 1, 0.02)
+	wm.Update(1000)
+	wg.Wait()
+}
+
+func TestWatermeterGetFlowSeedOnly(t *testing.T) {
+	assert := assert.New(t)
+
+	durations := []time.Duration{-time.Minute, 0, time.Nanosecond, time.Second, time.Minute, 24 * time.Hour}
+
+	wm := Watermeter{Timeout: time.Hour}
+	setNow(&wm, 0)
+	wm.Init(1500)
+	for _, d := range durations {
+		assert.Equal(0.0, wm.GetFlow(d), "duration %s", d)
+	}
+
+	// The seed is in the future relative to the clock.
+	setNow(&wm, 30)
+	wm.Init(1500)
+	setNow(&wm, 0)
+	for _, d := range durations {
+		assert.Equal(0.0, wm.GetFlow(d), "duration %s", d)
+	}
+}
+
+func TestWatermeterDefaultTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	wm := Watermeter{}
+	setNow(&wm, 0)
+	wm.Init(0)
+	assert.Equal(DefaultTimeout, wm.Timeout)
+
+	for min := 1; min <= 10; min++ {
+		setNow(&wm, min)
+		wm.Update(500)
+	}
+
+	// Nothing within the hour was pruned.
+	assert.Equal(11, wm.events.Len())
+	assert.Equal(0.5, wm.GetFlow(10*time.Minute))
+
+	wm = Watermeter{Timeout: -time.Minute}
+	wm.Init(0)
+	assert.Equal(DefaultTimeout, wm.Timeout)
+}
+
+func TestWatermeterUpdateSigned(t *testing.T) {
+	assert := assert.New(t)
+
+	var errs []error
+	var mutex sync.Mutex
+	wm := Watermeter{
+		Timeout:       time.Hour,
+		Bidirectional: true,
+		OnError: func(err error) {
+			mutex.Lock()
+			errs = append(errs, err)
+			mutex.Unlock()
+		},
+	}
+	setNow(&wm, 0)
+	wm.Init(10000)
+
+	// Alternate forward and reverse, with more in reverse.
+	for min := 1; min <= 10; min++ {
+		setNow(&wm, min)
+		if 0 == min%2 {
+			wm.UpdateSigned(-1500)
+		} else {
+			wm.UpdateSigned(1000)
+		}
+	}
+	assert.Equal(uint64(7500), wm.total)
+	assert.Equal(-0.25, wm.GetFlow(10*time.Minute))
+	assert.Equal(uint64(0), wm.GetVolume(10*time.Minute))
+
+	// The total can't go below zero.
+	setNow(&wm, 11)
+	wm.UpdateSigned(-8000)
+	assert.Equal(uint64(7500), wm.total)
+
+	// A forward only meter rejects reverse flow.
+	fwd := Watermeter{OnError: wm.OnError}
+	fwd.Init(10000)
+	fwd.UpdateSigned(-1000)
+	fwd.UpdateSigned(1000)
+	assert.Equal(uint64(11000), fwd.total)
+
+	assert.Eventually(func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return 2 == len(errs)
+	}, time.Second, time.Millisecond)
+	mutex.Lock()
+	assert.ElementsMatch([]error{ErrNegativeTotal, ErrReverseFlow}, errs)
+	mutex.Unlock()
+}
+
+func TestWatermeterConcurrentUse(t *testing.T) {
+	assert := assert.New(t)
+
+	// The pool bounds the callback goroutines.
+	pool := NewPool(2, 16)
+	defer pool.Close()
+
+	wm := Watermeter{Timeout: time.Minute, MaxEvents: 1000, Pool: pool}
+	wm.Init(0)
+	defer wm.Close()
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	run := func(fn func(i int)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+					fn(i)
+				}
+			}
+		}()
+	}
+
+	var updates [4]int
+	for g := range updates {
+		g := g
+		run(func(int) {
+			wm.Update(250)
+			updates[g]++
+		})
+	}
+	run(func(int) { wm.GetFlow(time.Second) })
+	run(func(int) { wm.GetGallons() })
+	run(func(i int) {
+		if 0 == i%100 {
+			_ = wm.String()
+		}
+	})
+	run(func(i int) {
+		if 0 == i%2 {
+			wm.SetUsage(func(uint64, float64) {})
+			wm.SetChange(nil)
+		} else {
+			wm.SetUsage(nil)
+			wm.SetChange(func() {})
+		}
+		wm.SetTimeout(time.Duration(1+i%60) * time.Second)
+		runtime.Gosched()
+	})
+
+	time.Sleep(200 * time.Millisecond)
+	close(stop)
+	wg.Wait()
+
+	var sum uint64
+	for _, n := range updates {
+		sum += uint64(n) * 250
+	}
+	assert.Equal(sum, wm.total)
+	assert.Equal(sum/1000, wm.GetGallons())
+}
+
+func TestWatermeterReset(t *testing.T) {
+	assert := assert.New(t)
+
+	wm := Watermeter{Timeout: time.Hour}
+	setNow(&wm, 0)
+	wm.Init(2000)
+	for min := 1; min <= 3; min++ {
+		setNow(&wm, min)
+		wm.Update(1500)
+	}
+	assert.Equal(uint64(6), wm.GetGallons())
+	assert.Equal(uint64(4), wm.GetLifetimeGallons())
+
+	setNow(&wm, 4)
+	wm.Reset()
+	assert.Equal(uint64(0), wm.GetGallons())
+	assert.Equal(uint64(4), wm.GetLifetimeGallons())
+	assert.Equal(1, wm.events.Len())
+	assert.Equal(0.0, wm.GetFlow(10*time.Minute))
+	assert.Nil(wm.Validate())
+
+	setNow(&wm, 5)
+	wm.Update(2000)
+	assert.Equal(uint64(2), wm.GetGallons())
+	assert.Equal(uint64(6), wm.GetLifetimeGallons())
+
+	// Neither does Init clear it.
+	wm.Init(0)
+	assert.Equal(uint64(6), wm.GetLifetimeGallons())
+}
+
+func TestWatermeterCoalesceWindow(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := time.Date(2016, time.December, 25, 1, 0, 0, 0, time.UTC)
+	wm := Watermeter{
+		Timeout:        time.Hour,
+		CoalesceWindow: time.Second,
+		now:            func() time.Time { return clock },
+	}
+	wm.Init(0)
+
+	// Ten pulses 100ms apart land in one event.
+	first := clock.Add(time.Minute)
+	for i := 0; i < 10; i++ {
+		clock = first.Add(time.Duration(i) * 100 * time.Millisecond)
+		wm.Update(250)
+	}
+	assert.Equal(2, wm.events.Len())
+	e := wm.events.Front().Value.(*entry)
+	assert.True(first.Equal(e.time))
+	assert.Equal(uint64(2500), e.total)
+	assert.Equal(uint64(2500), wm.total)
+
+	// The window is measured from the stored event, not the last pulse.
+	clock = first.Add(time.Second)
+	wm.Update(250)
+	assert.Equal(3, wm.events.Len())
+	assert.Equal(uint64(2750), wm.total)
+	assert.Nil(wm.Validate())
+}
+
+func TestWatermeterInitE(t *testing.T) {
+	assert := assert.New(t)
+
+	wm := Watermeter{Timeout: time.Hour}
+	setNow(&wm, 0)
+
+	// -1000 computed with unsigned math.
+	var a, b uint64 = 1000, 2000
+	rv, err := wm.InitE(a - b)
+	assert.Nil(rv)
+	assert.Equal(ErrImplausibleInitial, err)
+
+	wm.MaxInitial = 1000000
+	rv, err = wm.InitE(1000001)
+	assert.Nil(rv)
+	assert.Equal(ErrImplausibleInitial, err)
+
+	rv, err = wm.InitE(1000000)
+	assert.Nil(err)
+	assert.Equal(&wm, rv)
+	assert.Equal(uint64(1000), wm.GetGallons())
+}
+
+func TestWatermeterSyncCallbacks(t *testing.T) {
+	assert := assert.New(t)
+
+	changes := 0
+	wm := Watermeter{
+		Timeout:       time.Hour,
+		SyncCallbacks: true,
+		Change:        func() { changes++ },
+	}
+	wm.Init(0)
+	wm.Update(100)
+	wm.Update(100)
+	assert.Equal(2, changes)
+}
+
+func TestWatermeterCallbackTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	errs := make(chan error, 4)
+	release := make(chan struct{})
+	defer close(release)
+
+	wm := Watermeter{
+		Timeout:         time.Hour,
+		SyncCallbacks:   true,
+		CallbackTimeout: 20 * time.Millisecond,
+		Change:          func() { <-release },
+		OnError:         func(err error) { errs <- err },
+	}
+	wm.Init(0)
+
+	start := time.Now()
+	wm.Update(100)
+	assert.Less(time.Since(start), time.Second)
+
+	select {
+	case err := <-errs:
+		assert.Equal(ErrCallbackTimeout, err)
+	default:
+		assert.Fail("OnError was not called")
+	}
+}
+
+func TestWatermeterGetGallonsQuantized(t *testing.T) {
+	assert := assert.New(t)
+
+	wm := Watermeter{Timeout: time.Hour}
+	wm.Init(1450)
+	assert.Equal(uint64(1000), wm.GetGallonsQuantized(1000))
+	assert.Equal(uint64(1500), wm.GetGallonsQuantized(500))
+	assert.Equal(uint64(1400), wm.GetGallonsQuantized(200))
+	assert.Equal(uint64(1450), wm.GetGallonsQuantized(1))
+	assert.Equal(uint64(0), wm.GetGallonsQuantized(0))
+
+	wm.Update(50)
+	assert.Equal(uint64(2000), wm.GetGallonsQuantized(1000))
+	assert.Equal(uint64(1500), wm.total)
+}
+
+func TestWatermeterUpdateBeforeInit(t *testing.T) {
+	assert := assert.New(t)
+
+	var wm Watermeter
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			wm.Update(500)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(uint64(4), wm.GetGallons())
+	assert.Equal(DefaultTimeout, wm.Timeout)
+	assert.Nil(wm.Validate())
+
+	var counter Watermeter
+	assert.NotPanics(func() { counter.UpdateAbsoluteCounter(100) })
+	assert.NotPanics(func() { counter.UpdateTagged(100, "sink") })
+	assert.Equal(uint64(100), counter.GetTaggedTotal("sink"))
+}
+
+func TestWatermeterUsageFlowWindow(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, window := range []time.Duration{0, 30 * time.Second} {
+		flows := make(chan float64, 1)
+		wm := Watermeter{
+			Timeout:         time.Hour,
+			UsageFlowWindow: window,
+			Usage:           func(gallons uint64, flow float64) { flows <- flow },
+		}
+		setNow(&wm, 0)
+		wm.Init(0)
+
+		// A slow dribble, then a burst completes the gallon.
+		setNow(&wm, 10)
+		wm.Update(300)
+		wm.now = func() time.Time { return at(10, 30) }
+		wm.Update(350)
+		wm.now = func() time.Time { return at(10, 45) }
+		simulated := wm.Simulate(350)
+		wm.Update(350)
+
+		select {
+		case flow := <-flows:
+			if 0 == window {
+				// The whole gallon over 10.75 minutes.
+				assert.InDelta(1/10.75, flow, 1e-9)
+			} else {
+				// 0.525 gallons over the last half minute, from the
+				// total interpolated at its start.
+				assert.InDelta(1.05, flow, 1e-9)
+			}
+			assert.Equal(simulated.Flow, flow)
+		case <-time.After(time.Second):
+			assert.Fail("Usage was not called")
+		}
+	}
+}